@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const albumPageSize = 24
+
+// albumQuery is the search, date range, sorting and page asked for in the
+// /codex/album query string.
+type albumQuery struct {
+	Query string
+	From  string
+	To    string
+	Sort  string
+	Page  int
+}
+
+var albumSorts = []string{"newest", "oldest", "title"}
+
+func parseAlbumQuery(v url.Values) (albumQuery, error) {
+	q := albumQuery{
+		Query: strings.TrimSpace(v.Get("q")),
+		From:  v.Get("from"),
+		To:    v.Get("to"),
+		Sort:  v.Get("sort"),
+		Page:  1,
+	}
+
+	for _, d := range []string{q.From, q.To} {
+		if _, err := time.Parse(dateLayout, d); d != "" && err != nil {
+			return albumQuery{}, errors.New("dates must be YYYY-MM-DD, got " + d)
+		}
+	}
+	if q.From != "" && q.To != "" && q.From > q.To {
+		q.From, q.To = q.To, q.From
+	}
+
+	if q.Sort == "" {
+		q.Sort = albumSorts[0]
+	}
+	if !slices.Contains(albumSorts, q.Sort) {
+		return albumQuery{}, errors.New("unknown sort " + q.Sort)
+	}
+
+	if p := v.Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return albumQuery{}, errors.New("page must be a positive number, got " + p)
+		}
+		q.Page = n
+	}
+
+	return q, nil
+}
+
+// values encodes q back into a query string, leaving defaults out.
+func (q albumQuery) values() url.Values {
+	v := url.Values{}
+	if q.Query != "" {
+		v.Set("q", q.Query)
+	}
+	if q.From != "" {
+		v.Set("from", q.From)
+	}
+	if q.To != "" {
+		v.Set("to", q.To)
+	}
+	if q.Sort != albumSorts[0] {
+		v.Set("sort", q.Sort)
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	return v
+}
+
+// Filtered reports whether any search or date filter is set.
+func (q albumQuery) Filtered() bool {
+	return q.Query != "" || q.From != "" || q.To != ""
+}
+
+func foldString(s string) string {
+	return strings.Map(fold, s)
+}
+
+// filter returns the images matching q, sorted as asked. Every word of the
+// search must appear in the title or the description, accents ignored.
+func (q albumQuery) filter(images []Image) []Image {
+	words := strings.Fields(foldString(q.Query))
+
+	var matched []Image
+	for _, img := range images {
+		if (q.From != "" || q.To != "") && !validDate(img.Date) {
+			continue
+		}
+		if q.From != "" && img.Date < q.From || q.To != "" && img.Date > q.To {
+			continue
+		}
+
+		text := foldString(img.Title + " " + img.Description)
+		if !allContained(text, words) {
+			continue
+		}
+
+		matched = append(matched, img)
+	}
+
+	slices.SortStableFunc(matched, func(a, b Image) int {
+		switch q.Sort {
+		case "oldest":
+			return strings.Compare(a.Date, b.Date)
+		case "title":
+			return strings.Compare(foldString(a.Title), foldString(b.Title))
+		}
+		return strings.Compare(b.Date, a.Date)
+	})
+
+	return matched
+}
+
+// setAlbumURLs links every image to its anchor on the page of the unfiltered
+// album that lists it, so permalinks keep working past the first page.
+func setAlbumURLs(images []Image, pageSize int) {
+	q := albumQuery{Sort: albumSorts[0]}
+	page := map[string]int{}
+	for i, img := range q.filter(images) {
+		page[img.Filename] = i/pageSize + 1
+	}
+
+	for i, img := range images {
+		q.Page = page[img.Filename]
+		images[i].AlbumURL = "/codex/album"
+		if enc := q.values().Encode(); enc != "" {
+			images[i].AlbumURL += "?" + enc
+		}
+		images[i].AlbumURL += "#" + img.Filename
+	}
+}
+
+func validDate(d string) bool {
+	_, err := time.Parse(dateLayout, d)
+	return err == nil
+}
+
+func allContained(text string, words []string) bool {
+	for _, w := range words {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// albumView is what the gallery template renders: a page of images, the
+// query that selected them and links to the neighbouring pages. Archive is
+// set when rendering a year or month archive instead of the whole album.
+type albumView struct {
+	Images  []Image
+	Total   int
+	Query   albumQuery
+	Sorts   []string
+	Page    int
+	Pages   int
+	Prev    string
+	Next    string
+	Years   []albumPeriod
+	Archive *albumArchive
+}
+
+// albumArchive is a year or month of the album, with links to the months in
+// it and to the closest newer and older archives that have pictures.
+type albumArchive struct {
+	Title  string
+	Months []albumPeriod
+	Newer  *albumPeriod
+	Older  *albumPeriod
+}
+
+type albumPeriod struct {
+	Label string
+	URL   string
+}
+
+// newAlbumView paginates images. ok is false if the page is past the end.
+func newAlbumView(path string, q albumQuery, images []Image, pageSize int) (albumView, bool) {
+	view := albumView{
+		Total: len(images),
+		Query: q,
+		Sorts: albumSorts,
+		Page:  q.Page,
+		Pages: max(1, (len(images)+pageSize-1)/pageSize),
+	}
+	if q.Page > view.Pages {
+		return albumView{}, false
+	}
+
+	start := (q.Page - 1) * pageSize
+	view.Images = images[start:min(start+pageSize, len(images))]
+
+	link := func(page int) string {
+		q.Page = page
+		if enc := q.values().Encode(); enc != "" {
+			return path + "?" + enc
+		}
+		return path
+	}
+	if view.Page > 1 {
+		view.Prev = link(view.Page - 1)
+	}
+	if view.Page < view.Pages {
+		view.Next = link(view.Page + 1)
+	}
+
+	return view, true
+}
+
+// archiveHandler serves /codex/album/{year}/{month}. Year archives come in
+// through galleryHandler, as they share the pattern of the image files.
+func (g *Gallery) archiveHandler(w http.ResponseWriter, r *http.Request) {
+	g.archive(w, r, r.PathValue("year"), r.PathValue("month"))
+}
+
+func (g *Gallery) archive(w http.ResponseWriter, r *http.Request, year, month string) {
+	if !isArchiveYear(year) || month != "" && !isArchiveMonth(month) {
+		http.NotFound(w, r)
+		return
+	}
+
+	q, err := parseAlbumQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q = albumQuery{Sort: albumSorts[0], Page: q.Page}
+
+	period := year
+	if month != "" {
+		period += "-" + month
+	}
+
+	all, tmpl, updatedAt := g.album()
+
+	var images []Image
+	for _, img := range q.filter(all) {
+		if validDate(img.Date) && strings.HasPrefix(img.Date, period+"-") {
+			images = append(images, img)
+		}
+	}
+	if len(images) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	view, ok := newAlbumView(r.URL.Path, q, images, g.pageSize)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	archive := &albumArchive{Title: archiveLabel(period)}
+	periods := archivePeriods(all, len(period))
+	if i := slices.Index(periods, period); i > 0 {
+		archive.Newer = &albumPeriod{archiveLabel(periods[i-1]), archiveURL(periods[i-1])}
+	}
+	if i := slices.Index(periods, period); i < len(periods)-1 {
+		archive.Older = &albumPeriod{archiveLabel(periods[i+1]), archiveURL(periods[i+1])}
+	}
+	if month == "" {
+		for _, p := range archivePeriods(images, len("2006-01")) {
+			archive.Months = append(archive.Months, albumPeriod{archiveLabel(p), archiveURL(p)})
+		}
+	}
+	view.Archive = archive
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "gallery", view); err != nil {
+		slog.Error("failed to render album archive", "err", err, "period", period)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeHTML(w, r, &buf, updatedAt)
+}
+
+func isArchiveYear(s string) bool {
+	_, err := time.Parse("2006", s)
+	return len(s) == 4 && err == nil
+}
+
+func isArchiveMonth(s string) bool {
+	_, err := time.Parse("01", s)
+	return len(s) == 2 && err == nil
+}
+
+// archivePeriods returns the distinct years (n = 4) or months (n = 7) of the
+// images' dates, newest first.
+func archivePeriods(images []Image, n int) []string {
+	var periods []string
+	for _, img := range images {
+		if validDate(img.Date) {
+			periods = append(periods, img.Date[:n])
+		}
+	}
+	slices.Sort(periods)
+	periods = slices.Compact(periods)
+	slices.Reverse(periods)
+	return periods
+}
+
+func albumYears(images []Image) []albumPeriod {
+	var years []albumPeriod
+	for _, y := range archivePeriods(images, len("2006")) {
+		years = append(years, albumPeriod{y, archiveURL(y)})
+	}
+	return years
+}
+
+// archiveURL maps "2025" to /codex/album/2025 and "2025-03" to
+// /codex/album/2025/03.
+func archiveURL(period string) string {
+	return "/codex/album/" + strings.Replace(period, "-", "/", 1)
+}
+
+func archiveLabel(period string) string {
+	if t, err := time.Parse("2006-01", period); err == nil {
+		return t.Format("January 2006")
+	}
+	return period
+}
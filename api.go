@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// The read-only JSON API mirrors what the codex pages show, so other tools
+// don't have to scrape HTML. Only live posts are listed.
+
+type apiPage struct {
+	Page
+	URL string `json:"url"`
+}
+
+type apiImage struct {
+	Image
+	URL  string `json:"url"`
+	MIME string `json:"mimeType,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// apiScriptumHandler serves /api/scriptum, listing posts without their
+// content, and /api/scriptum/{slug}, with it.
+func (c *Codex) apiScriptumHandler(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	pages := c.Scriptum.Published()
+	modtime := c.Scriptum.ModTime()
+
+	if slug == "" {
+		list := make([]apiPage, len(pages))
+		for i, p := range pages {
+			p.Content = ""
+			list[i] = apiPage{Page: p, URL: postURL(p)}
+		}
+		writeAPI(w, r, map[string]any{"pages": list}, modtime)
+		return
+	}
+
+	for _, p := range pages {
+		if p.Slug == slug {
+			writeAPI(w, r, apiPage{Page: p, URL: postURL(p)}, modtime)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "no such post")
+}
+
+// apiAlbumHandler serves /api/album, listing the images' metadata, and
+// /api/album/{fileName}, which adds the type and size of the image.
+func (c *Codex) apiAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := r.PathValue("fileName")
+	images := c.Gallery.ImageList()
+	modtime := c.Gallery.UpdatedAt()
+
+	if fileName == "" {
+		list := make([]apiImage, len(images))
+		for i, img := range images {
+			list[i] = apiImage{Image: img, URL: imageURL(img.Filename)}
+		}
+		writeAPI(w, r, map[string]any{"images": list}, modtime)
+		return
+	}
+
+	for _, img := range images {
+		if img.Filename == fileName {
+			resp := apiImage{Image: img, URL: imageURL(img.Filename)}
+			resp.MIME, resp.Size, _ = c.Gallery.Enclosure(img.Filename)
+			writeAPI(w, r, resp, modtime)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "no such image")
+}
+
+func writeAPI(w http.ResponseWriter, r *http.Request, v any, modtime time.Time) {
+	writeJSONCached(w, r, v, "application/json", modtime)
+}
+
+// writeJSONCached encodes v and writes it like writeHTML, with an ETag.
+// Responses may be read from any origin.
+func writeJSONCached(w http.ResponseWriter, r *http.Request, v any, contentType string, modtime time.Time) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "error encoding response")
+		slog.Error("Error encoding JSON", "err", err, "path", r.URL.Path)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeCached(w, r, &buf, contentType, modtime)
+}
@@ -5,31 +5,50 @@ import (
 	"errors"
 	"html/template"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type Codex struct {
 	Scriptum  *Scriptum
 	Gallery   *Gallery
+	mu        sync.RWMutex
 	indexTmpl *template.Template
+	loadedAt  time.Time
 }
 
 func newCodex(s *Scriptum, g *Gallery) (*Codex, error) {
+	c := &Codex{
+		Scriptum: s,
+		Gallery:  g,
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Codex) reload() error {
 	indexTmpl, err := template.ParseGlob("templates/*.go.html")
 	if err != nil {
-		return nil, errors.New("error parsing codex template: " + err.Error())
+		return errors.New("error parsing codex template: " + err.Error())
 	}
 
-	return &Codex{
-		Scriptum:  s,
-		Gallery:   g,
-		indexTmpl: indexTmpl,
-	}, nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.indexTmpl = indexTmpl
+	c.loadedAt = time.Now()
+
+	return nil
 }
 
 func (c *Codex) codexHandler(w http.ResponseWriter, r *http.Request) {
 	var latestPost Page
 	if c.Scriptum != nil {
-		latestPost = c.Scriptum.Pages[0]
+		latestPost, _ = c.Scriptum.LatestPage()
 	}
 
 	var dailyImage Image
@@ -45,13 +64,41 @@ func (c *Codex) codexHandler(w http.ResponseWriter, r *http.Request) {
 		DailyImage: dailyImage,
 	}
 
+	// Render under the lock, but release it before writing to the client so
+	// a slow one doesn't hold up reloads.
 	var buf bytes.Buffer
+	c.mu.RLock()
 	err := c.indexTmpl.ExecuteTemplate(&buf, "codex", data)
+	modtime := c.lastModified()
+	c.mu.RUnlock()
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-	buf.WriteTo(w)
+	writeHTML(w, r, &buf, modtime)
+}
+
+// lastModified is the latest time any content shown in the codex index may
+// have changed. The picture of the day rolls over at midnight UTC.
+func (c *Codex) lastModified() time.Time {
+	now := time.Now().UTC()
+	modtime := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if c.loadedAt.After(modtime) {
+		modtime = c.loadedAt
+	}
+	if c.Scriptum != nil {
+		if t := c.Scriptum.ModTime(); t.After(modtime) {
+			modtime = t
+		}
+	}
+	if c.Gallery != nil {
+		if t := c.Gallery.UpdatedAt(); t.After(modtime) {
+			modtime = t
+		}
+	}
+
+	return modtime
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const devPollInterval = 500 * time.Millisecond
+
+// devReloader polls template and content directories for changes and runs
+// the reload functions when any file is added, removed or modified. Reloads
+// swap their state in under the owners' locks, so in-flight requests finish
+// with what they started with. The last reload error is shown in the browser
+// until a reload succeeds.
+type devReloader struct {
+	dirs    []string
+	reloads []func() error
+
+	mu       sync.RWMutex
+	err      error
+	snapshot map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newDevReloader(dirs []string, reloads ...func() error) *devReloader {
+	d := &devReloader{dirs: dirs, reloads: reloads}
+	d.snapshot = d.scan()
+	return d
+}
+
+func (d *devReloader) run(ctx context.Context) {
+	slog.Info("Dev mode: watching for changes", "dirs", d.dirs)
+
+	// Startup loads are lenient about broken posts, so check once up front.
+	d.reload()
+
+	ticker := time.NewTicker(devPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snapshot := d.scan()
+			if !stampsEqual(snapshot, d.snapshot) {
+				d.snapshot = snapshot
+				d.reload()
+			}
+		}
+	}
+}
+
+func (d *devReloader) reload() {
+	var errs []error
+	for _, reload := range d.reloads {
+		if err := reload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Dev mode: reload failed", "err", err)
+		return
+	}
+	slog.Info("Dev mode: reloaded templates and pages")
+}
+
+// scan stamps every file in the watched directories, skipping the gallery
+// cache and encoded content, which aren't templates.
+func (d *devReloader) scan() map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+
+	for _, dir := range d.dirs {
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				if path == cacheDir || path == filepath.Join(galleryPath, "content") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+// middleware replaces pages with the last reload error, if there is one.
+// Static files keep being served so the error page is styled.
+func (d *devReloader) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.RLock()
+		err := d.err
+		d.mu.RUnlock()
+
+		if err == nil || strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <link rel="stylesheet" media="all" href="/static/styles/codex-style.css">
+  <title>Reload error</title>
+</head>
+<body>
+  <h1>Reload error</h1>
+  <p>Fix the file and save it again, this page will go away on the next successful reload.</p>
+  <pre>` + html.EscapeString(err.Error()) + `</pre>
+</body>
+</html>
+`))
+	})
+}
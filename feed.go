@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// siteURL is the origin used for the absolute URLs feeds require.
+const siteURL = "https://rattz.xyz"
+
+const albumFeedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"xml:base,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel      string `xml:"rel,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Href     string `xml:"href,attr"`
+	HrefLang string `xml:"hreflang,attr,omitempty"`
+	Length   int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Lang       string         `xml:"xml:lang,attr,omitempty"`
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          rssSelf   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func postURL(p Page) string {
+	return siteURL + "/codex/scriptum/" + url.PathEscape(p.Slug)
+}
+
+func imageURL(fileName string) string {
+	return siteURL + "/codex/album/" + url.PathEscape(fileName)
+}
+
+// enclosure describes a gallery image for a feed, if the gallery has it.
+func (c *Codex) enclosure(fileName string) (href, mime string, length int64, ok bool) {
+	if c.Gallery == nil || fileName == "" {
+		return "", "", 0, false
+	}
+	mime, length, ok = c.Gallery.Enclosure(fileName)
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+	return imageURL(fileName), mime, length, ok
+}
+
+// feedUpdated returns the latest of the pages' update times, or fallback if
+// there are no pages.
+func feedUpdated(pages []Page, fallback time.Time) time.Time {
+	if len(pages) == 0 {
+		return fallback
+	}
+	updated := pages[0].UpdatedAt()
+	for _, p := range pages[1:] {
+		if t := p.UpdatedAt(); t.After(updated) {
+			updated = t
+		}
+	}
+	return updated
+}
+
+// scriptumAtomHandler serves /codex/scriptum/feed.atom with the full content
+// of every live post.
+func (c *Codex) scriptumAtomHandler(w http.ResponseWriter, r *http.Request) {
+	pages := c.Scriptum.Published()
+	modtime := c.Scriptum.ModTime()
+
+	feed := atomFeed{
+		Base:    siteURL + "/",
+		Title:   "Codex Scriptum",
+		ID:      siteURL + "/codex/scriptum",
+		Updated: feedUpdated(pages, modtime).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: siteURL + "/codex/scriptum/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: siteURL + "/codex/scriptum"},
+		},
+		Author: atomPerson{Name: defaultAuthor},
+	}
+
+	for _, p := range pages {
+		entry := atomEntry{
+			Lang:      p.Lang,
+			Title:     p.Title,
+			ID:        postURL(p),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: postURL(p)}},
+			Published: p.PublishedAt().Format(time.RFC3339),
+			Updated:   p.UpdatedAt().Format(time.RFC3339),
+			Summary:   &atomText{Type: "text", Body: p.Desc},
+			Content:   atomText{Type: "html", Body: string(p.Content)},
+		}
+		if p.Author != defaultAuthor {
+			entry.Author = &atomPerson{Name: p.Author}
+		}
+		for _, tag := range p.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		for _, t := range translations(p, pages) {
+			entry.Links = append(entry.Links, atomLink{Rel: "alternate", Type: "text/html", Href: postURL(t), HrefLang: t.Lang})
+		}
+		if href, mime, length, ok := c.enclosure(p.Cover); ok {
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Type: mime, Href: href, Length: length})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	writeFeed(w, r, feed, "application/atom+xml; charset=UTF-8", modtime)
+}
+
+// scriptumRSSHandler serves /codex/scriptum/feed.rss, for readers that don't
+// speak Atom.
+func (c *Codex) scriptumRSSHandler(w http.ResponseWriter, r *http.Request) {
+	pages := c.Scriptum.Published()
+	modtime := c.Scriptum.ModTime()
+
+	feed := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         "Codex Scriptum",
+			Link:          siteURL + "/codex/scriptum",
+			Description:   "Texts by " + defaultAuthor + ".",
+			LastBuildDate: feedUpdated(pages, modtime).Format(time.RFC1123Z),
+			Self:          rssSelf{Href: siteURL + "/codex/scriptum/feed.rss", Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+
+	for _, p := range pages {
+		item := rssItem{
+			Title:       p.Title,
+			Link:        postURL(p),
+			GUID:        postURL(p),
+			PubDate:     p.PublishedAt().Format(time.RFC1123Z),
+			Description: string(p.Content),
+			Categories:  p.Tags,
+		}
+		if href, mime, length, ok := c.enclosure(p.Cover); ok {
+			item.Enclosure = &rssEnclosure{URL: href, Length: length, Type: mime}
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	writeFeed(w, r, feed, "application/rss+xml; charset=UTF-8", modtime)
+}
+
+// albumAtomHandler serves /codex/album/feed.atom with the newest images.
+func (c *Codex) albumAtomHandler(w http.ResponseWriter, r *http.Request) {
+	images := c.Gallery.ImageList()
+	modtime := c.Gallery.UpdatedAt()
+	if len(images) > albumFeedEntries {
+		images = images[:albumFeedEntries]
+	}
+
+	feed := atomFeed{
+		Base:    siteURL + "/",
+		Title:   "Codex Album",
+		ID:      siteURL + "/codex/album",
+		Updated: modtime.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: siteURL + "/codex/album/feed.atom"},
+			{Rel: "alternate", Type: "text/html", Href: siteURL + "/codex/album"},
+		},
+		Author: atomPerson{Name: defaultAuthor},
+	}
+
+	var latest time.Time
+	for _, img := range images {
+		// Images without a valid date take the time of the last sync.
+		updated := modtime.UTC()
+		if t, err := time.Parse(dateLayout, img.Date); err == nil {
+			updated = t
+		}
+
+		content := `<p><img src="` + html.EscapeString(imageURL(img.Filename)) + `" alt="` + html.EscapeString(img.Title) + `"></p>`
+		if img.Description != "" {
+			content += "<p>" + html.EscapeString(img.Description) + "</p>"
+		}
+
+		entry := atomEntry{
+			Title:   img.Title,
+			ID:      imageURL(img.Filename),
+			Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: siteURL + img.AlbumURL}},
+			Updated: updated.Format(time.RFC3339),
+			Content: atomText{Type: "html", Body: content},
+		}
+		if href, mime, length, ok := c.enclosure(img.Filename); ok {
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Type: mime, Href: href, Length: length})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+		if updated.After(latest) {
+			latest = updated
+		}
+	}
+	if !latest.IsZero() {
+		feed.Updated = latest.Format(time.RFC3339)
+	}
+
+	writeFeed(w, r, feed, "application/atom+xml; charset=UTF-8", modtime)
+}
+
+func writeFeed(w http.ResponseWriter, r *http.Request, feed any, contentType string, modtime time.Time) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("Error rendering feed", "err", err, "path", r.URL.Path)
+		return
+	}
+
+	writeCached(w, r, &buf, contentType, modtime)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Authors     []jsonAuthor   `json:"authors"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Summary       string           `json:"summary"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished string           `json:"date_published"`
+	DateModified  string           `json:"date_modified"`
+	Authors       []jsonAuthor     `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Language      string           `json:"language"`
+	Attachments   []jsonAttachment `json:"attachments,omitempty"`
+}
+
+type jsonAttachment struct {
+	URL         string `json:"url"`
+	MIMEType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+// scriptumJSONFeedHandler serves /codex/scriptum/feed.json, a JSON Feed 1.1.
+func (c *Codex) scriptumJSONFeedHandler(w http.ResponseWriter, r *http.Request) {
+	pages := c.Scriptum.Published()
+
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       "Codex Scriptum",
+		HomePageURL: siteURL + "/codex/scriptum",
+		FeedURL:     siteURL + "/codex/scriptum/feed.json",
+		Description: "Texts by " + defaultAuthor + ".",
+		Authors:     []jsonAuthor{{Name: defaultAuthor}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, p := range pages {
+		item := jsonFeedItem{
+			ID:            postURL(p),
+			URL:           postURL(p),
+			Title:         p.Title,
+			ContentHTML:   string(p.Content),
+			Summary:       p.Desc,
+			DatePublished: p.PublishedAt().Format(time.RFC3339),
+			DateModified:  p.UpdatedAt().Format(time.RFC3339),
+			Tags:          p.Tags,
+			Language:      p.Lang,
+		}
+		if p.Author != defaultAuthor {
+			item.Authors = []jsonAuthor{{Name: p.Author}}
+		}
+		if href, mime, length, ok := c.enclosure(p.Cover); ok {
+			item.Image = href
+			item.Attachments = []jsonAttachment{{URL: href, MIMEType: mime, SizeInBytes: length}}
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	writeJSONCached(w, r, feed, "application/feed+json; charset=UTF-8", c.Scriptum.ModTime())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAlbumAtomLinks checks that album entries link to the album page that
+// lists them, and that enclosures come from the loaded metadata rather than
+// from reading the files again.
+func TestAlbumAtomLinks(t *testing.T) {
+	g := newTestGallery(t, "Gecko.rio", "Oven.rio")
+	g.pageSize = 1
+	if err := g.loadFromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	c := &Codex{Gallery: g}
+
+	w := httptest.NewRecorder()
+	c.albumAtomHandler(w, httptest.NewRequest(http.MethodGet, "/codex/album/feed.atom", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(feed.Entries))
+	}
+
+	for i, e := range feed.Entries {
+		img := g.Images[i]
+		links := map[string]atomLink{}
+		for _, l := range e.Links {
+			links[l.Rel] = l
+		}
+
+		if want := siteURL + img.AlbumURL; links["alternate"].Href != want {
+			t.Errorf("%s: alternate link %q, want %q", img.Filename, links["alternate"].Href, want)
+		}
+		mime, length, _ := g.Enclosure(img.Filename)
+		if enc := links["enclosure"]; enc.Href != imageURL(img.Filename) || enc.Type != mime || enc.Length != length || length == 0 {
+			t.Errorf("%s: enclosure %+v, want %s, %d bytes", img.Filename, enc, mime, length)
+		}
+	}
+
+	if _, _, ok := g.Enclosure(filepath.Join("..", "Gecko.rio")); ok {
+		t.Error("Enclosure found a file outside the gallery")
+	}
+}
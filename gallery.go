@@ -2,8 +2,8 @@ package main
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -20,41 +20,57 @@ import (
 )
 
 const (
-	galleryURL  = "https://api.github.com/repos/lucasrattz/rattz.xyz/contents/gallery/content"
-	galleryPath = "gallery"
-	cacheDir    = galleryPath + "/cache"
-	cacheFile   = cacheDir + "/cache.json"
+	galleryTreeURL = "https://api.github.com/repos/lucasrattz/rattz.xyz/git/trees/main:gallery/content"
+	galleryRawURL  = "https://raw.githubusercontent.com/lucasrattz/rattz.xyz/main/gallery/content/"
+	galleryPath    = "gallery"
+	cacheDir       = galleryPath + "/cache"
+	cacheFile      = cacheDir + "/cache.json"
+
+	downloadPrefix = ".download-"
+	galleryWorkers = 4
 )
 
 type galleryCache map[string]string
 
-type githubFile struct {
-	Name        string `json:"name"`
-	DownloadURL string `json:"download_url"`
-	SHA         string `json:"sha"`
-}
-
 type Image struct {
 	Filename    string `json:"filename"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Date        string `json:"date"`
+	AlbumURL    string `json:"-"` // the album page listing the image, see setAlbumURLs
 }
 
 type Gallery struct {
 	mu        sync.RWMutex
 	Images    []Image
+	fulls     map[string]rioRendition // full renditions by file name, for enclosures
 	indexTmpl *template.Template
+	shas      galleryCache
+	updatedAt time.Time
+	pageSize  int
+
+	source GallerySource
+	syncMu sync.Mutex
+	images *imageCache
+	thumbs *thumbStore
+
+	thumbMu   sync.Mutex
+	thumbless map[string]bool
 }
 
-func newGallery() (*Gallery, error) {
-	tmpl, err := template.ParseGlob(galleryPath + "/*.go.html")
+func newGallery(source GallerySource, cacheBytes int64, pageSize int) (*Gallery, error) {
+	tmpl, err := parseGalleryTemplates()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing gallery templates: %w", err)
+		return nil, err
 	}
 
 	g := &Gallery{
 		indexTmpl: tmpl,
+		source:    source,
+		images:    newImageCache(cacheBytes),
+		thumbs:    newThumbStore(),
+		thumbless: map[string]bool{},
+		pageSize:  pageSize,
 	}
 
 	if err := g.loadFromDisk(); err != nil {
@@ -64,11 +80,34 @@ func newGallery() (*Gallery, error) {
 	return g, nil
 }
 
-func (g *Gallery) loadFromDisk() error {
+func parseGalleryTemplates() (*template.Template, error) {
+	tmpl, err := template.ParseGlob(galleryPath + "/*.go.html")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing gallery templates: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (g *Gallery) reloadTemplates() error {
+	tmpl, err := parseGalleryTemplates()
+	if err != nil {
+		return err
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	g.indexTmpl = tmpl
+	g.updatedAt = time.Now()
+
+	return nil
+}
+
+// loadFromDisk reads the metadata of every cached file. Files are read and
+// verified before taking the lock, which is only held to swap them in.
+func (g *Gallery) loadFromDisk() error {
 	entries := []Image{}
+	fulls := map[string]rioRendition{}
 
 	err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -78,13 +117,14 @@ func (g *Gallery) loadFromDisk() error {
 			return nil
 		}
 
-		meta, _, err := decodeBinFile(path)
+		meta, full, err := decodeBinFile(path)
 		if err != nil {
 			slog.Warn("failed to decode gallery file", "path", path, "err", err)
 			return nil
 		}
 
 		entries = append(entries, meta)
+		fulls[meta.Filename] = full
 		return nil
 	})
 	if err != nil {
@@ -100,117 +140,338 @@ func (g *Gallery) loadFromDisk() error {
 		return t1.After(t2)
 	})
 
+	setAlbumURLs(entries, g.pageSize)
+
+	shas, err := loadCache(cacheFile)
+	if err != nil {
+		slog.Warn("failed to load gallery cache, falling back to file stats for ETags", "err", err)
+		shas = galleryCache{}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.Images = entries
+	g.fulls = fulls
+	g.shas = shas
+	g.updatedAt = time.Now()
+
 	return nil
 }
 
-func decodeBinFile(path string) (Image, []byte, error) {
-	f, err := os.Open(path)
+// decodeBinFile reads the metadata of a .rio file and verifies its full
+// rendition, returning where that rendition is.
+func decodeBinFile(path string) (Image, rioRendition, error) {
+	f, rf, err := openRio(path)
 	if err != nil {
-		return Image{}, nil, err
+		return Image{}, rioRendition{}, err
 	}
 	defer f.Close()
 
-	var metaLen uint32
-	if err := binary.Read(f, binary.LittleEndian, &metaLen); err != nil {
-		return Image{}, nil, err
+	full, err := rf.rendition(rioFull)
+	if err != nil {
+		return rf.Meta, rioRendition{}, err
 	}
 
-	metaBytes := make([]byte, metaLen)
-	if _, err := io.ReadFull(f, metaBytes); err != nil {
-		return Image{}, nil, err
+	if _, err := readRendition(f, full); err != nil {
+		return rf.Meta, rioRendition{}, fmt.Errorf("%s: %w", path, err)
 	}
 
-	var meta Image
-	if err := json.Unmarshal(metaBytes, &meta); err != nil {
-		return Image{}, nil, err
-	}
+	return rf.Meta, full, nil
+}
 
-	var imgLen uint32
-	if err := binary.Read(f, binary.LittleEndian, &imgLen); err != nil {
-		return meta, nil, err
+func (g *Gallery) galleryHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := r.PathValue("fileName")
+	if isArchiveYear(fileName) {
+		gzipHandler(func(w http.ResponseWriter, r *http.Request) {
+			g.archive(w, r, fileName, "")
+		})(w, r)
+		return
 	}
 
-	imgBytes := make([]byte, imgLen)
-	if _, err := io.ReadFull(f, imgBytes); err != nil {
-		return meta, nil, err
-	}
+	if fileName == "" {
+		q, err := parseAlbumQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	return meta, imgBytes, nil
-}
+		images, tmpl, updatedAt := g.album()
 
-func (g *Gallery) galleryHandler(w http.ResponseWriter, r *http.Request) {
-	fileName := r.PathValue("fileName")
+		view, ok := newAlbumView(r.URL.Path, q, q.filter(images), g.pageSize)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
 
-	g.mu.RLock()
-	defer g.mu.RUnlock()
+		view.Years = albumYears(images)
 
-	if fileName == "" {
 		var buf bytes.Buffer
-		err := g.indexTmpl.ExecuteTemplate(&buf, "gallery", g.Images)
+		err = tmpl.ExecuteTemplate(&buf, "gallery", view)
 		if err != nil {
 			slog.Error("failed to render gallery", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-		buf.WriteTo(w)
+		writeHTML(w, r, &buf, updatedAt)
 		return
 	}
 
+	// PathValue decodes %2F, so the name could point outside the cache.
+	if filepath.Base(fileName) != fileName || !strings.HasSuffix(fileName, ".rio") {
+		http.NotFound(w, r)
+		return
+	}
 	path := filepath.Join(cacheDir, fileName)
-	if !strings.HasSuffix(fileName, ".rio") || !fileExists(path) {
+
+	size := r.URL.Query().Get("size")
+	if size != "" && size != rioFull && size != rioThumb {
+		http.Error(w, "Unknown size "+size, http.StatusBadRequest)
+		return
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	_, imgBytes, err := decodeBinFile(path)
+	version := g.version(fileName, stat)
+	w.Header().Set("ETag", etag(version, size))
+
+	key := imageKey{fileName: fileName, rendition: rioFull, version: version}
+	if size == rioThumb && !g.isThumbless(fileName) {
+		key.rendition = rioThumb
+	}
+
+	if data, ok := g.thumbs.get(key); ok {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeContent(w, r, fileName, stat.ModTime(), bytes.NewReader(data))
+		return
+	}
+	if data, mime, ok := g.images.get(key); ok {
+		w.Header().Set("Content-Type", mime)
+		http.ServeContent(w, r, fileName, stat.ModTime(), bytes.NewReader(data))
+		return
+	}
+
+	f, rf, err := openRio(path)
 	if err != nil {
 		slog.Error("failed to read image", "path", path, "err", err)
 		http.Error(w, "Failed to read image", http.StatusInternalServerError)
 		return
 	}
+	defer f.Close()
 
-	w.Header().Set("Content-Type", "image/webp")
-	w.Write(imgBytes)
+	rend, err := rf.rendition(rioFull)
+	if err != nil {
+		slog.Error("failed to read image", "path", path, "err", err)
+		http.Error(w, "Failed to read image", http.StatusInternalServerError)
+		return
+	}
+
+	if key.rendition == rioThumb {
+		if thumb, err := rf.rendition(rioThumb); err == nil {
+			rend = thumb
+		} else if data, ok := g.generatedThumbnail(key, f, rend); ok {
+			w.Header().Set("Content-Type", "image/jpeg")
+			http.ServeContent(w, r, fileName, stat.ModTime(), bytes.NewReader(data))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", rend.MIME)
+
+	if g.images.fits(rend.Length) {
+		data, err := readRendition(f, rend)
+		if err != nil {
+			slog.Error("failed to read image", "path", path, "err", err)
+			http.Error(w, "Failed to read image", http.StatusInternalServerError)
+			return
+		}
+
+		key.rendition = rend.Name
+		g.images.add(key, data, rend.MIME)
+		http.ServeContent(w, r, fileName, stat.ModTime(), bytes.NewReader(data))
+		return
+	}
+
+	// Too large to cache. Payload checksums were verified when the gallery was
+	// loaded from disk, so the section is streamed as is.
+	http.ServeContent(w, r, fileName, stat.ModTime(), io.NewSectionReader(f, rend.Offset, rend.Length))
 }
 
-func updateGallery(g *Gallery) error {
-	files, err := listGithubFiles()
+// version identifies the contents of a gallery file. The GitHub blob SHA in
+// cache.json already hashes the file; files missing from it fall back to
+// their modification time and size. It's part of the image cache key, so
+// bytes read just before a sync replaced the file can't be served under the
+// new version.
+func (g *Gallery) version(fileName string, stat os.FileInfo) string {
+	g.mu.RLock()
+	sha, ok := g.shas[fileName]
+	g.mu.RUnlock()
+
+	if ok {
+		return sha
+	}
+	return fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size())
+}
+
+// etag is the strong validator of an image rendition.
+func etag(version, size string) string {
+	if size == rioThumb {
+		version += "-" + rioThumb
+	}
+	return `"` + version + `"`
+}
+
+// album returns what the album pages are rendered from. The lock is only held
+// while copying them, so a slow client can't hold up loadFromDisk.
+func (g *Gallery) album() ([]Image, *template.Template, time.Time) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.Images, g.indexTmpl, g.updatedAt
+}
+
+// UpdatedAt returns when the gallery was last reloaded from disk.
+func (g *Gallery) UpdatedAt() time.Time {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.updatedAt
+}
+
+// CacheStats returns the counters of the decoded image cache.
+func (g *Gallery) CacheStats() imageCacheStats {
+	stats := g.images.stats()
+	stats.Generated = g.thumbs.len()
+	return stats
+}
+
+// invalidate drops everything cached for a file whose contents changed.
+func (g *Gallery) invalidate(fileName string) {
+	g.images.remove(fileName)
+	g.thumbs.remove(fileName)
+
+	g.thumbMu.Lock()
+	delete(g.thumbless, fileName)
+	g.thumbMu.Unlock()
+}
+
+func (g *Gallery) isThumbless(fileName string) bool {
+	g.thumbMu.Lock()
+	defer g.thumbMu.Unlock()
+	return g.thumbless[fileName]
+}
+
+// generatedThumbnail downscales the full rendition of a .rio file encoded
+// without a thumb rendition and keeps it in g.thumbs. It reports false when
+// the format can't be decoded, in which case the full image should be served
+// instead; the file is then marked as thumbless.
+func (g *Gallery) generatedThumbnail(key imageKey, f io.ReaderAt, full rioRendition) ([]byte, bool) {
+	return g.thumbs.generate(key, func() ([]byte, bool) {
+		return g.makeThumbnail(key.fileName, f, full)
+	})
+}
+
+func (g *Gallery) makeThumbnail(fileName string, f io.ReaderAt, full rioRendition) ([]byte, bool) {
+	img, err := readRendition(f, full)
 	if err != nil {
-		return err
+		slog.Warn("failed to read image for thumbnail", "file", fileName, "err", err)
+		return nil, false
 	}
 
+	data, err := makeThumbnail(img, thumbWidth)
+	if errors.Is(err, errThumbUnsupported) {
+		g.thumbMu.Lock()
+		g.thumbless[fileName] = true
+		g.thumbMu.Unlock()
+		return nil, false
+	}
+	if err != nil {
+		slog.Warn("failed to generate thumbnail", "file", fileName, "err", err)
+		return nil, false
+	}
+
+	return data, true
+}
+
+// gallerySync summarizes the changes made by updateGallery.
+type gallerySync struct {
+	Downloaded []string `json:"downloaded"`
+	Removed    []string `json:"removed"`
+	Failed     []string `json:"failed"`
+}
+
+func updateGallery(g *Gallery) (*gallerySync, error) {
+	g.syncMu.Lock()
+	defer g.syncMu.Unlock()
+
+	files, err := g.source.List()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &gallerySync{Downloaded: []string{}, Removed: []string{}, Failed: []string{}}
+
 	os.MkdirAll(cacheDir, 0o755)
 	cache, _ := loadCache(cacheFile)
 
 	currentFiles := map[string]bool{}
 
+	var (
+		wg      sync.WaitGroup
+		cacheMu sync.Mutex
+		workers = make(chan struct{}, galleryWorkers)
+	)
+
 	for _, f := range files {
-		if !strings.HasSuffix(f.Name, ".rio") {
+		if !strings.HasSuffix(f.Name, ".rio") || f.Name != filepath.Base(f.Name) {
 			continue
 		}
 		currentFiles[f.Name] = true
 
-		destPath := filepath.Join(cacheDir, f.Name)
-		if cache[f.Name] != f.SHA {
-			if err := downloadFile(f.DownloadURL, destPath); err != nil {
+		if cache[f.Name] == f.SHA {
+			continue
+		}
+
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() { <-workers; wg.Done() }()
+
+			if err := downloadFile(g.source, f, filepath.Join(cacheDir, f.Name)); err != nil {
 				slog.Error("failed to download file", "file", f.Name, "err", err)
-				continue
+				cacheMu.Lock()
+				summary.Failed = append(summary.Failed, f.Name)
+				cacheMu.Unlock()
+				return
 			}
+
+			cacheMu.Lock()
 			cache[f.Name] = f.SHA
-		}
+			summary.Downloaded = append(summary.Downloaded, f.Name)
+			cacheMu.Unlock()
+			g.invalidate(f.Name)
+		}()
 	}
 
+	wg.Wait()
+
 	diskFiles, _ := os.ReadDir(cacheDir)
 	for _, f := range diskFiles {
+		if strings.HasPrefix(f.Name(), downloadPrefix) {
+			os.Remove(filepath.Join(cacheDir, f.Name()))
+			continue
+		}
 		if f.IsDir() || !strings.HasSuffix(f.Name(), ".rio") {
 			continue
 		}
 		if !currentFiles[f.Name()] {
 			os.Remove(filepath.Join(cacheDir, f.Name()))
 			delete(cache, f.Name())
+			g.invalidate(f.Name())
+			summary.Removed = append(summary.Removed, f.Name())
 			slog.Info("removed deleted file", "file", f.Name())
 		}
 	}
@@ -219,7 +480,13 @@ func updateGallery(g *Gallery) error {
 		slog.Warn("failed to save cache", "err", err)
 	}
 
-	return g.loadFromDisk()
+	stats := g.CacheStats()
+	slog.Info("gallery image cache", "hits", stats.Hits, "misses", stats.Misses, "items", stats.Items, "bytes", stats.Bytes, "budget", stats.Budget, "generated_thumbnails", stats.Generated)
+
+	sort.Strings(summary.Downloaded)
+	sort.Strings(summary.Failed)
+
+	return summary, g.loadFromDisk()
 }
 
 func loadCache(path string) (galleryCache, error) {
@@ -242,48 +509,98 @@ func saveCache(path string, cache galleryCache) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, b, 0o644)
+	return writeFileAtomic(path, b)
 }
 
-func listGithubFiles() ([]githubFile, error) {
-	resp, err := http.Get(galleryURL)
+// downloadFile fetches a file from the source into a temporary file next to
+// dest, checks it against the source's git blob SHA and as a .rio file, and
+// only then renames it over dest. Readers never see a partial download.
+func downloadFile(src GallerySource, file galleryFile, dest string) error {
+	body, err := src.Open(file)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	tmp, err := os.CreateTemp(filepath.Dir(dest), downloadPrefix+"*")
+	if err != nil {
+		return err
 	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
 
-	var files []githubFile
-	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
-		return nil, err
+	_, err = io.Copy(tmp, body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	sha, err := gitBlobSHA(tmpPath)
+	if err != nil {
+		return err
+	}
+	if sha != file.SHA {
+		return fmt.Errorf("downloaded file has SHA %s, expected %s", sha, file.SHA)
 	}
 
-	return files, nil
+	if err := verifyRio(tmpPath); err != nil {
+		return fmt.Errorf("downloaded file is not a valid .rio: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, dest)
 }
 
-func downloadFile(url, dest string) error {
-	resp, err := http.Get(url)
+// writeFileAtomic writes data to a temporary file and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), downloadPrefix+"*")
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer os.Remove(tmp.Name())
 
-	f, err := os.Create(dest)
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, resp.Body)
-	return err
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ImageList returns the images, newest first.
+func (g *Gallery) ImageList() []Image {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.Images
 }
 
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+// Enclosure returns the MIME type and size of an image's full rendition,
+// as served at /codex/album/{fileName}, from the metadata read when the
+// gallery was loaded.
+func (g *Gallery) Enclosure(fileName string) (string, int64, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	full, ok := g.fulls[fileName]
+	return full.MIME, full.Length, ok
 }
 
 func (g *Gallery) ImageOfTheDay() (Image, error) {
@@ -1,15 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	_ "golang.org/x/image/webp"
 )
 
+const rioVersion = 2
+
+var rioMagic = [4]byte{0x89, 'R', 'I', 'O'}
+
 type ImageMeta struct {
 	Filename    string `json:"filename"`
 	Title       string `json:"title"`
@@ -17,9 +34,26 @@ type ImageMeta struct {
 	Date        string `json:"date"`
 }
 
+// metaInput is an entry of metadata.json. Renditions maps extra rendition
+// names (e.g. "thumb") to image files inside the images directory; the
+// "full" rendition is always Filename.
+type metaInput struct {
+	ImageMeta
+	Renditions map[string]string `json:"renditions"`
+}
+
+type rendition struct {
+	name   string
+	mime   string
+	width  uint32
+	height uint32
+	data   []byte
+}
+
 func main() {
 	outDir := flag.String("output", "./", "Output directory for encoded files")
 	flag.StringVar(outDir, "o", "./", "Output directory for encoded files (shorthand)")
+	thumbWidth := flag.Int("thumb-width", 400, "Width of generated thumbnails, 0 disables them")
 	flag.Parse()
 
 	args := flag.Args()
@@ -36,7 +70,7 @@ func main() {
 		panic(err)
 	}
 
-	var metas []ImageMeta
+	var metas []metaInput
 	if err := json.Unmarshal(metaBytes, &metas); err != nil {
 		panic(err)
 	}
@@ -46,28 +80,193 @@ func main() {
 	}
 
 	for _, meta := range metas {
-		imgPath := filepath.Join(imagesPath, meta.Filename)
-		imgData, err := os.ReadFile(imgPath)
+		sources := map[string]string{"full": meta.Filename}
+		for name, file := range meta.Renditions {
+			if name == "full" {
+				panic(fmt.Sprintf("%s: rendition name \"full\" is reserved", meta.Filename))
+			}
+			sources[name] = file
+		}
+
+		renditions, err := loadRenditions(imagesPath, sources)
 		if err != nil {
-			panic(fmt.Sprintf("Failed to read image %s: %v", imgPath, err))
+			panic(fmt.Sprintf("Failed to read renditions of %s: %v", meta.Filename, err))
+		}
+
+		if _, ok := sources["thumb"]; !ok && *thumbWidth > 0 {
+			if thumb, ok := makeThumbnail(renditions[0], *thumbWidth); ok {
+				renditions = append(renditions, thumb)
+			} else {
+				fmt.Printf("Skipping thumbnail for %s: format can't be decoded\n", meta.Filename)
+			}
 		}
 
 		outFile := filepath.Join(*outDir, strings.TrimSuffix(meta.Filename, filepath.Ext(meta.Filename))+".rio")
-		out, err := os.Create(outFile)
+
+		mBytes, _ := json.Marshal(meta.ImageMeta)
+		b, err := encodeRio(mBytes, renditions)
 		if err != nil {
+			panic(fmt.Sprintf("Failed to encode %s: %v", meta.Filename, err))
+		}
+
+		if err := os.WriteFile(outFile, b, 0o644); err != nil {
 			panic(err)
 		}
 
-		mBytes, _ := json.Marshal(meta)
-		binary.Write(out, binary.LittleEndian, uint32(len(mBytes)))
-		_, _ = out.Write(mBytes)
+		fmt.Printf("Encoded: %s -> %s (%d renditions)\n", meta.Filename, outFile, len(renditions))
+	}
+
+	fmt.Println("All images encoded.")
+}
+
+func loadRenditions(dir string, sources map[string]string) ([]rendition, error) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		// full always comes first
+		if names[i] == "full" || names[j] == "full" {
+			return names[i] == "full"
+		}
+		return names[i] < names[j]
+	})
+
+	var renditions []rendition
+	for _, name := range names {
+		path := filepath.Join(dir, sources[name])
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
 
-		binary.Write(out, binary.LittleEndian, uint32(len(imgData)))
-		_, _ = out.Write(imgData)
-		out.Close()
+		r := rendition{name: name, mime: http.DetectContentType(data), data: data}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			r.width, r.height = uint32(cfg.Width), uint32(cfg.Height)
+		}
 
-		fmt.Printf("Encoded: %s -> %s\n", meta.Filename, outFile)
+		renditions = append(renditions, r)
 	}
 
-	fmt.Println("All images encoded.")
+	return renditions, nil
+}
+
+// encodeRio writes a .rio v2 file. See rio.go in the server for the layout.
+func encodeRio(meta []byte, renditions []rendition) ([]byte, error) {
+	if len(renditions) == 0 || len(renditions) > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid rendition count %d", len(renditions))
+	}
+	if len(meta) > math.MaxUint32 {
+		return nil, fmt.Errorf("metadata too large")
+	}
+
+	headerLen := 4 + 2 + 2 + 4 + sha256.Size
+	for _, r := range renditions {
+		if len(r.name) > math.MaxUint8 || len(r.mime) > math.MaxUint8 {
+			return nil, fmt.Errorf("rendition %q: name or mime type too long", r.name)
+		}
+		if len(r.data) > math.MaxUint32 {
+			return nil, fmt.Errorf("rendition %q: payload too large", r.name)
+		}
+		headerLen += 1 + len(r.name) + 1 + len(r.mime) + 4 + 4 + 8 + 4 + sha256.Size
+	}
+	headerLen += 4 // CRC
+
+	var header bytes.Buffer
+	header.Write(rioMagic[:])
+	binary.Write(&header, binary.LittleEndian, uint16(rioVersion))
+	binary.Write(&header, binary.LittleEndian, uint16(len(renditions)))
+	binary.Write(&header, binary.LittleEndian, uint32(len(meta)))
+	metaSum := sha256.Sum256(meta)
+	header.Write(metaSum[:])
+
+	offset := uint64(headerLen + len(meta))
+	for _, r := range renditions {
+		header.WriteByte(uint8(len(r.name)))
+		header.WriteString(r.name)
+		header.WriteByte(uint8(len(r.mime)))
+		header.WriteString(r.mime)
+		binary.Write(&header, binary.LittleEndian, r.width)
+		binary.Write(&header, binary.LittleEndian, r.height)
+		binary.Write(&header, binary.LittleEndian, offset)
+		binary.Write(&header, binary.LittleEndian, uint32(len(r.data)))
+		sum := sha256.Sum256(r.data)
+		header.Write(sum[:])
+		offset += uint64(len(r.data))
+	}
+	binary.Write(&header, binary.LittleEndian, crc32.ChecksumIEEE(header.Bytes()))
+
+	out := bytes.NewBuffer(make([]byte, 0, offset))
+	out.Write(header.Bytes())
+	out.Write(meta)
+	for _, r := range renditions {
+		out.Write(r.data)
+	}
+
+	return out.Bytes(), nil
+}
+
+// makeThumbnail downscales a GIF, JPEG, PNG or WebP rendition into a JPEG
+// thumbnail.
+func makeThumbnail(full rendition, width int) (rendition, bool) {
+	src, _, err := image.Decode(bytes.NewReader(full.data))
+	if err != nil {
+		return rendition{}, false
+	}
+
+	img := downscale(src, width)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return rendition{}, false
+	}
+
+	b := img.Bounds()
+	return rendition{
+		name:   "thumb",
+		mime:   "image/jpeg",
+		width:  uint32(b.Dx()),
+		height: uint32(b.Dy()),
+		data:   buf.Bytes(),
+	}, true
+}
+
+// downscale shrinks src to the given width with a box filter, keeping the
+// aspect ratio.
+func downscale(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+
+		for x := range width {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestGallery changes into a temporary directory and copies the named
+// files of gallery/content into its gallery cache.
+func newTestGallery(t *testing.T, files ...string) *Gallery {
+	t.Helper()
+
+	content, err := filepath.Abs(filepath.Join(galleryPath, "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Join(content, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Gallery{images: newImageCache(64 << 20), thumbs: newThumbStore(), thumbless: map[string]bool{}, shas: galleryCache{}}
+}
+
+func serveImage(g *Gallery, fileName, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/codex/album/"+fileName+query, nil)
+	r.SetPathValue("fileName", fileName)
+	w := httptest.NewRecorder()
+	g.galleryHandler(w, r)
+	return w
+}
+
+func TestGalleryHandlerFileNames(t *testing.T) {
+	g := newTestGallery(t, "Gecko.rio")
+	if err := os.WriteFile(filepath.Join(galleryPath, "Outside.rio"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		fileName string
+		want     int
+	}{
+		{"Gecko.rio", http.StatusOK},
+		{"Missing.rio", http.StatusNotFound},
+		{"cache.json", http.StatusNotFound},
+		{"../Outside.rio", http.StatusNotFound},
+		{"sub/../Gecko.rio", http.StatusNotFound},
+	} {
+		if w := serveImage(g, tt.fileName, ""); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.fileName, w.Code, tt.want)
+		}
+	}
+}
+
+// TestImageCacheVersion checks that bytes cached for an older version of a
+// file, e.g. read by a request racing a sync, aren't served once the gallery
+// has loaded the new SHA.
+func TestImageCacheVersion(t *testing.T) {
+	g := newTestGallery(t, "Gecko.rio")
+	g.shas["Gecko.rio"] = "old"
+	g.images.add(imageKey{fileName: "Gecko.rio", rendition: rioFull, version: "old"}, []byte("stale"), "image/webp")
+
+	if w := serveImage(g, "Gecko.rio", ""); w.Body.String() != "stale" {
+		t.Fatalf("expected the cached bytes for the current version, got %d bytes", w.Body.Len())
+	}
+
+	g.shas["Gecko.rio"] = "new"
+	w := serveImage(g, "Gecko.rio", "")
+	if w.Body.String() == "stale" {
+		t.Error("served bytes cached for the old version")
+	}
+	if etag := w.Header().Get("ETag"); etag != `"new"` {
+		t.Errorf("ETag = %s, want \"new\"", etag)
+	}
+}
+
+func TestSetAlbumURLs(t *testing.T) {
+	images := []Image{
+		{Filename: "c.rio", Date: "2025-01-03"},
+		{Filename: "b.rio", Date: "2025-01-02"},
+		{Filename: "a.rio", Date: "2025-01-01"},
+	}
+	setAlbumURLs(images, 2)
+
+	for i, want := range []string{
+		"/codex/album#c.rio",
+		"/codex/album#b.rio",
+		"/codex/album?page=2#a.rio",
+	} {
+		if got := images[i].AlbumURL; got != want {
+			t.Errorf("%s: AlbumURL = %q, want %q", images[i].Filename, got, want)
+		}
+	}
+}
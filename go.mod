@@ -1,3 +1,5 @@
 module rattz.xyz
 
 go 1.25.0
+
+require golang.org/x/image v0.36.0
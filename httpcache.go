@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified sets the ETag and Last-Modified validators on w and reports
+// whether the request's conditional headers match them, in which case a 304
+// has already been written. If-None-Match takes precedence over
+// If-Modified-Since, as in RFC 9110. A zero modtime skips Last-Modified.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modtime time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" || !etagMatch(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modtime.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil || modtime.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch does the weak comparison of If-None-Match against etag.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHTML writes a rendered page with a weak ETag derived from its content,
+// answering conditional requests with 304.
+func writeHTML(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer, modtime time.Time) {
+	writeCached(w, r, buf, "text/html; charset=UTF-8", modtime)
+}
+
+// writeCached is writeHTML for any content type.
+func writeCached(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer, contentType string, modtime time.Time) {
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if notModified(w, r, etag, modtime) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	buf.WriteTo(w)
+}
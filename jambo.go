@@ -0,0 +1,261 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Jambo is the markup language of scriptum posts. A post is frontmatter
+// followed by blocks separated by blank lines:
+//
+//	! Heading            (h2; !! for h3, !!! for h4)
+//	- list item          (consecutive items form a list)
+//	anything else        (a paragraph, lines are joined)
+//
+// Inside blocks:
+//
+//	*bold*  ~italic~  _underline_   (only at word boundaries, so snake_case
+//	                                 and 5 * 3 stay as they are)
+//	[text|https://example.com]   link
+//	[!alt text|/static/a.webp]   image
+//	^[footnote text]             footnote, listed at the end of the post
+//	\*                           a literal character
+//
+// Markers that aren't closed are shown as written. Everything else is
+// HTML-escaped, so posts can't inject markup.
+
+type jamboError struct {
+	line int
+	msg  string
+}
+
+func (e jamboError) Error() string {
+	return "jambo: line " + strconv.Itoa(e.line) + ": " + e.msg
+}
+
+type jamboRenderer struct {
+	out       strings.Builder
+	footnotes []string
+}
+
+// renderJambo renders a Jambo post body to HTML. line is the number of the
+// body's first line in the file, used in error messages.
+func renderJambo(src string, line int) (template.HTML, error) {
+	r := &jamboRenderer{}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); {
+		text := strings.TrimSpace(lines[i])
+		start := line + i
+
+		switch {
+		case text == "":
+			i++
+		case strings.HasPrefix(text, "!"):
+			if err := r.heading(text, start); err != nil {
+				return "", err
+			}
+			i++
+		case strings.HasPrefix(text, "- "):
+			r.out.WriteString("<ul>\n")
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "- "); i++ {
+				item, err := r.inline(strings.TrimSpace(lines[i])[2:], line+i)
+				if err != nil {
+					return "", err
+				}
+				r.out.WriteString("<li>" + item + "</li>\n")
+			}
+			r.out.WriteString("</ul>\n")
+		default:
+			var para []string
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if t == "" || strings.HasPrefix(t, "!") || strings.HasPrefix(t, "- ") {
+					break
+				}
+				para = append(para, t)
+			}
+			text, err := r.inline(strings.Join(para, " "), start)
+			if err != nil {
+				return "", err
+			}
+			r.out.WriteString("<p>" + text + "</p>\n")
+		}
+	}
+
+	if len(r.footnotes) > 0 {
+		r.out.WriteString("<section class=\"footnotes\">\n<hr />\n<ol>\n")
+		for i, note := range r.footnotes {
+			n := strconv.Itoa(i + 1)
+			r.out.WriteString(`<li id="fn-` + n + `">` + note + ` <a href="#fnref-` + n + `">↩</a></li>` + "\n")
+		}
+		r.out.WriteString("</ol>\n</section>\n")
+	}
+
+	return template.HTML(r.out.String()), nil
+}
+
+func (r *jamboRenderer) heading(text string, line int) error {
+	level := len(text) - len(strings.TrimLeft(text, "!"))
+	if level > 3 {
+		return jamboError{line, "headings go up to !!!"}
+	}
+
+	content, err := r.inline(strings.TrimSpace(text[level:]), line)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		return jamboError{line, "empty heading"}
+	}
+
+	tag := "h" + strconv.Itoa(level+1)
+	r.out.WriteString("<" + tag + ">" + content + "</" + tag + ">\n")
+	return nil
+}
+
+var jamboEmphasis = map[rune]string{
+	'*': "strong",
+	'~': "em",
+	'_': "u",
+}
+
+type jamboDelim struct {
+	c    rune
+	part int // index of the marker in the rendered parts
+}
+
+// inline renders the inline markup of a block. Emphasis markers open at the
+// start of a word and close at its end; a closer matches the nearest open
+// marker of its kind, and markers opened after that one are left as text.
+func (r *jamboRenderer) inline(text string, line int) (string, error) {
+	var parts []string
+	var stack []jamboDelim
+	open := map[rune]int{}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			parts = append(parts, html.EscapeString(string(runes[i])))
+
+		case jamboEmphasis[c] != "":
+			before, after := ' ', ' '
+			if i > 0 {
+				before = runes[i-1]
+			}
+			if i+1 < len(runes) {
+				after = runes[i+1]
+			}
+			canOpen := !unicode.IsSpace(after) && !isWordRune(before)
+			canClose := !unicode.IsSpace(before) && before != c && !isWordRune(after)
+
+			parts = append(parts, string(c))
+			if canClose && open[c] > 0 {
+				k := len(stack) - 1
+				for stack[k].c != c {
+					open[stack[k].c]--
+					k--
+				}
+				tag := jamboEmphasis[c]
+				parts[stack[k].part] = "<" + tag + ">"
+				parts[len(parts)-1] = "</" + tag + ">"
+				open[c]--
+				stack = stack[:k]
+			} else if canOpen {
+				stack = append(stack, jamboDelim{c, len(parts) - 1})
+				open[c]++
+			}
+
+		case c == '^' && i+1 < len(runes) && runes[i+1] == '[':
+			end := closingBracket(runes, i+1)
+			if end < 0 {
+				return "", jamboError{line, "unclosed footnote"}
+			}
+			note, err := r.inline(string(runes[i+2:end]), line)
+			if err != nil {
+				return "", err
+			}
+			r.footnotes = append(r.footnotes, note)
+			n := strconv.Itoa(len(r.footnotes))
+			parts = append(parts, `<sup id="fnref-`+n+`"><a href="#fn-`+n+`">`+n+`</a></sup>`)
+			i = end
+
+		case c == '[':
+			end := closingBracket(runes, i)
+			if end < 0 {
+				return "", jamboError{line, "unclosed link"}
+			}
+			inner := string(runes[i+1 : end])
+			image := strings.HasPrefix(inner, "!")
+			inner = strings.TrimPrefix(inner, "!")
+
+			sep := strings.LastIndex(inner, "|")
+			if sep < 0 {
+				return "", jamboError{line, "link needs a target: [text|url]"}
+			}
+			label, target := inner[:sep], strings.TrimSpace(inner[sep+1:])
+			if !safeJamboURL(target) {
+				return "", jamboError{line, "unsupported link target " + strconv.Quote(target)}
+			}
+
+			if image {
+				parts = append(parts, `<img src="`+html.EscapeString(target)+`" alt="`+html.EscapeString(label)+`">`)
+			} else {
+				content, err := r.inline(label, line)
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, `<a href="`+html.EscapeString(target)+`">`+content+`</a>`)
+			}
+			i = end
+
+		default:
+			parts = append(parts, html.EscapeString(string(c)))
+		}
+	}
+
+	return strings.Join(parts, ""), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// closingBracket returns the index of the ] matching the [ at start,
+// honoring nesting and escapes, or -1.
+func closingBracket(runes []rune, start int) int {
+	depth := 0
+	for i := start; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// safeJamboURL allows http(s), mailto, site-relative and fragment targets,
+// keeping javascript: and data: URLs out of posts.
+func safeJamboURL(u string) bool {
+	lower := strings.ToLower(u)
+	for _, prefix := range []string{"https://", "http://", "mailto:", "/", "#"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
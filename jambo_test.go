@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestRenderJambo(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"paragraph", "one\ntwo\n\nthree", "<p>one two</p>\n<p>three</p>\n"},
+		{"headings", "! A\n!! B\n!!! C", "<h2>A</h2>\n<h3>B</h3>\n<h4>C</h4>\n"},
+		{"list", "- a\n- *b*\n\ntext", "<ul>\n<li>a</li>\n<li><strong>b</strong></li>\n</ul>\n<p>text</p>\n"},
+		{"emphasis", "*bold* ~italic~ _under_", "<p><strong>bold</strong> <em>italic</em> <u>under</u></p>\n"},
+		{"nested emphasis", "*a ~b~ c*", "<p><strong>a <em>b</em> c</strong></p>\n"},
+		{"snake case", "snake_case and a_b_c", "<p>snake_case and a_b_c</p>\n"},
+		{"arithmetic", "5 * 3 = 15 and 2*2", "<p>5 * 3 = 15 and 2*2</p>\n"},
+		{"unclosed", "*open and ~also", "<p>*open and ~also</p>\n"},
+		{"crossed", "*a ~b* c~", "<p><strong>a ~b</strong> c~</p>\n"},
+		{"doubled", "a ** b", "<p>a ** b</p>\n"},
+		{"punctuation", "(*x*), _y_.", "<p>(<strong>x</strong>), <u>y</u>.</p>\n"},
+		{"escape", `\*not bold\*`, "<p>*not bold*</p>\n"},
+		{"html", `<script>alert("x")</script> & co`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; co</p>\n"},
+		{"link", "[the *site*|https://rattz.xyz/?a=1&b=2]", `<p><a href="https://rattz.xyz/?a=1&amp;b=2">the <strong>site</strong></a></p>` + "\n"},
+		{"image", `[!a "cat"|/static/cat.webp]`, `<p><img src="/static/cat.webp" alt="a &#34;cat&#34;"></p>` + "\n"},
+		{
+			"footnote",
+			"text^[a *note*]",
+			"<p>text<sup id=\"fnref-1\"><a href=\"#fn-1\">1</a></sup></p>\n" +
+				"<section class=\"footnotes\">\n<hr />\n<ol>\n" +
+				"<li id=\"fn-1\">a <strong>note</strong> <a href=\"#fnref-1\">↩</a></li>\n</ol>\n</section>\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderJambo(tt.src, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderJamboErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"javascript link", "[x|javascript:alert(1)]", `jambo: line 5: unsupported link target "javascript:alert(1)"`},
+		{"data image", "\n[!x|data:image/png;base64,AAAA]", `jambo: line 6: unsupported link target "data:image/png;base64,AAAA"`},
+		{"relative link", "[x|evil.example]", `jambo: line 5: unsupported link target "evil.example"`},
+		{"no target", "[x]", "jambo: line 5: link needs a target: [text|url]"},
+		{"unclosed link", "[x|/a", "jambo: line 5: unclosed link"},
+		{"unclosed footnote", "a^[note", "jambo: line 5: unclosed footnote"},
+		{"deep heading", "!!!! x", "jambo: line 5: headings go up to !!!"},
+		{"empty heading", "!!", "jambo: line 5: empty heading"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderJambo(tt.src, 5)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("err = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const defaultLang = "en"
+
+// langMatches reports whether a post in lang satisfies want, comparing the
+// whole tag and then the primary language: want pt matches pt-BR.
+func langMatches(lang, want string) bool {
+	if strings.EqualFold(lang, want) {
+		return true
+	}
+	primary, _, _ := strings.Cut(lang, "-")
+	wantPrimary, _, _ := strings.Cut(want, "-")
+	return strings.EqualFold(primary, wantPrimary)
+}
+
+// negotiateLang picks the language of available preferred by an
+// Accept-Language header, or "" if none is acceptable.
+func negotiateLang(header string, available []string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+
+	var prefs []pref
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{tag, q})
+		}
+	}
+	slices.SortStableFunc(prefs, func(a, b pref) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+
+	for _, p := range prefs {
+		for _, lang := range available {
+			if strings.EqualFold(lang, p.tag) {
+				return lang
+			}
+		}
+		for _, lang := range available {
+			if langMatches(lang, p.tag) {
+				return lang
+			}
+		}
+	}
+
+	return ""
+}
+
+// pageLangs returns the languages of pages in order of appearance.
+func pageLangs(pages []Page) []string {
+	var langs []string
+	for _, p := range pages {
+		if !slices.Contains(langs, p.Lang) {
+			langs = append(langs, p.Lang)
+		}
+	}
+	return langs
+}
+
+// translations returns the pages among pages that are translations of p:
+// pages that p translates, that translate p, or that translate the same
+// original.
+func translations(p Page, pages []Page) []Page {
+	original := p.Slug
+	if p.Translates != "" {
+		original = p.Translates
+	}
+
+	var ts []Page
+	for _, other := range pages {
+		if other.Slug == p.Slug {
+			continue
+		}
+		if other.Slug == original || other.Translates == original {
+			ts = append(ts, other)
+		}
+	}
+	return ts
+}
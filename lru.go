@@ -0,0 +1,200 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// imageCache is a least-recently-used cache of decoded image renditions,
+// bounded by the total size of the cached payloads.
+type imageCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[imageKey]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type imageKey struct {
+	fileName  string
+	rendition string
+	version   string // see Gallery.version
+}
+
+type imageEntry struct {
+	key  imageKey
+	data []byte
+	mime string
+}
+
+type imageCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Items  int    `json:"items"`
+	Bytes  int64  `json:"bytes"`
+	Budget int64  `json:"budget"`
+
+	// Thumbnails generated for files without a thumb rendition, which are
+	// kept outside the budget.
+	Generated int `json:"generated_thumbnails"`
+}
+
+func newImageCache(maxBytes int64) *imageCache {
+	return &imageCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[imageKey]*list.Element{},
+	}
+}
+
+func (c *imageCache) get(key imageKey) ([]byte, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, "", false
+	}
+
+	c.hits.Add(1)
+	c.order.MoveToFront(el)
+	e := el.Value.(*imageEntry)
+	return e.data, e.mime, true
+}
+
+// fits reports whether an item of n bytes is worth caching. Anything larger
+// than a quarter of the budget would evict most of the cache on its own.
+func (c *imageCache) fits(n int64) bool {
+	return n <= c.maxBytes/4
+}
+
+func (c *imageCache) add(key imageKey, data []byte, mime string) {
+	if !c.fits(int64(len(data))) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.order.PushFront(&imageEntry{key: key, data: data, mime: mime})
+	c.size += int64(len(data))
+
+	for c.size > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove drops every cached rendition of a file.
+func (c *imageCache) remove(fileName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if key.fileName == fileName {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *imageCache) removeElement(el *list.Element) {
+	e := c.order.Remove(el).(*imageEntry)
+	delete(c.items, e.key)
+	c.size -= int64(len(e.data))
+}
+
+func (c *imageCache) stats() imageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return imageCacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Items:  len(c.items),
+		Bytes:  c.size,
+		Budget: c.maxBytes,
+	}
+}
+
+// thumbStore keeps the thumbnails generated for files without a thumb
+// rendition. They're small and costly to make, so unlike imageCache it
+// ignores the budget, and concurrent requests for the same thumbnail wait
+// for a single generation.
+type thumbStore struct {
+	mu      sync.Mutex
+	thumbs  map[imageKey][]byte
+	pending map[imageKey]*thumbCall
+}
+
+type thumbCall struct {
+	done chan struct{}
+	data []byte
+	ok   bool
+}
+
+func newThumbStore() *thumbStore {
+	return &thumbStore{thumbs: map[imageKey][]byte{}, pending: map[imageKey]*thumbCall{}}
+}
+
+func (s *thumbStore) get(key imageKey) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.thumbs[key]
+	return data, ok
+}
+
+// generate returns the thumbnail for key, calling gen if it isn't stored
+// and no other request is already making it. Failures aren't stored.
+func (s *thumbStore) generate(key imageKey, gen func() ([]byte, bool)) ([]byte, bool) {
+	s.mu.Lock()
+	if data, ok := s.thumbs[key]; ok {
+		s.mu.Unlock()
+		return data, true
+	}
+	if call, ok := s.pending[key]; ok {
+		s.mu.Unlock()
+		<-call.done
+		return call.data, call.ok
+	}
+	call := &thumbCall{done: make(chan struct{})}
+	s.pending[key] = call
+	s.mu.Unlock()
+
+	call.data, call.ok = gen()
+
+	s.mu.Lock()
+	delete(s.pending, key)
+	if call.ok {
+		s.thumbs[key] = call.data
+	}
+	s.mu.Unlock()
+	close(call.done)
+
+	return call.data, call.ok
+}
+
+// remove drops the thumbnails of every version of a file.
+func (s *thumbStore) remove(fileName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.thumbs {
+		if key.fileName == fileName {
+			delete(s.thumbs, key)
+		}
+	}
+}
+
+func (s *thumbStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.thumbs)
+}
@@ -2,18 +2,23 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 //go:embed static/*
@@ -21,6 +26,10 @@ var staticFiles embed.FS
 
 const (
 	profileFallback = "https://raw.githubusercontent.com/lucasrattz/rattz.xyz/main/profile.json"
+	galleryCacheMB  = 64
+
+	defaultSyncInterval = time.Hour
+	shutdownTimeout     = 10 * time.Second
 )
 
 var gzipExtensions = map[string]bool{
@@ -29,6 +38,10 @@ var gzipExtensions = map[string]bool{
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate-profile" {
+		os.Exit(validateProfileCmd(os.Args[2:]))
+	}
+
 	host, port := os.Getenv("HOST"), os.Getenv("PORT")
 	if host == "" {
 		host = "localhost"
@@ -37,6 +50,33 @@ func main() {
 		port = "5675"
 	}
 
+	cacheBytes := int64(galleryCacheMB) << 20
+	if mb := os.Getenv("GALLERY_CACHE_MB"); mb != "" {
+		n, err := strconv.ParseInt(mb, 10, 64)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid GALLERY_CACHE_MB %q", mb)
+		}
+		cacheBytes = n << 20
+	}
+
+	pageSize := albumPageSize
+	if size := os.Getenv("GALLERY_PAGE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid GALLERY_PAGE_SIZE %q", size)
+		}
+		pageSize = n
+	}
+
+	syncInterval, err := durationEnv("SYNC_INTERVAL", defaultSyncInterval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	syncJitter, err := durationEnv("SYNC_JITTER", syncInterval/10)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if os.Getenv("REMOTE_PROFILE_URL") == "" {
 		slog.Warn("Remote profile URL not set, fallback is " + profileFallback)
 	}
@@ -46,19 +86,20 @@ func main() {
 		log.Fatalf("%s", err.Error())
 	}
 
-	profileTmpl := template.Must(template.ParseGlob("profile/*.go.html"))
+	profileTmpl := new(atomic.Pointer[template.Template])
+	profileTmpl.Store(template.Must(parseProfileTemplates()))
 
-	scriptum, err := newScriptum()
+	scriptum, err := newScriptum(os.Getenv("SCRIPTUM_PREVIEW_TOKEN"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	gallery, err := newGallery()
+	gallery, err := newGallery(newGallerySource(os.Getenv("GALLERY_SOURCE"), os.Getenv("GITHUB_TOKEN")), cacheBytes, pageSize)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := updateGallery(gallery); err != nil {
+	if _, err := updateGallery(gallery); err != nil {
 		slog.Error("Failed to populate gallery on startup:", "err", err)
 	}
 
@@ -73,7 +114,23 @@ func main() {
 
 	router.HandleFunc("/", gzipHandler(codex.codexHandler))
 
-	router.HandleFunc("/update/", func(w http.ResponseWriter, r *http.Request) { updateHandler(w, r, gallery) })
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	updater := newUpdater(gallery, profileTmpl, os.Getenv("UPDATE_SECRET"), os.Getenv("UPDATE_TOKEN"), os.Getenv("TRUST_PROXY") != "")
+
+	schedulerDone := make(chan struct{})
+	if syncInterval > 0 {
+		updater.scheduler = newScheduler(gallery, profileTmpl, syncInterval, syncJitter)
+		go func() {
+			updater.scheduler.run(ctx)
+			close(schedulerDone)
+		}()
+	} else {
+		close(schedulerDone)
+	}
+
+	router.HandleFunc("/update/", updater.updateHandler)
 	router.Handle("/cefetdb/", http.RedirectHandler("https://cefetdb.rattz.xyz", http.StatusFound))
 	router.Handle("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/") {
@@ -87,19 +144,89 @@ func main() {
 	router.HandleFunc("/codex/", gzipHandler(codex.codexHandler))
 	router.HandleFunc("/codex/scriptum", gzipHandler(scriptum.scriptumHandler))
 	router.HandleFunc("/codex/scriptum/{id}", gzipHandler(scriptum.scriptumHandler))
+	router.HandleFunc("/codex/scriptum/feed.atom", gzipHandler(codex.scriptumAtomHandler))
+	router.HandleFunc("/codex/scriptum/feed.rss", gzipHandler(codex.scriptumRSSHandler))
+	router.HandleFunc("/codex/scriptum/feed.json", gzipHandler(codex.scriptumJSONFeedHandler))
+	router.HandleFunc("/codex/scriptum/search", gzipHandler(scriptum.searchHandler))
+	router.HandleFunc("/codex/scriptum/tags", gzipHandler(scriptum.tagsHandler))
+	router.HandleFunc("/codex/scriptum/tags/{tag}", gzipHandler(scriptum.tagsHandler))
 	router.HandleFunc("/codex/album", gzipHandler(gallery.galleryHandler))
-	router.HandleFunc("/codex/album/{fileName}", gzipHandler(gallery.galleryHandler))
+	router.HandleFunc("/codex/album/feed.atom", gzipHandler(codex.albumAtomHandler))
+	router.HandleFunc("/codex/album/{fileName}", gallery.galleryHandler)
+	router.HandleFunc("/codex/album/{year}/{month}", gzipHandler(gallery.archiveHandler))
+
+	router.HandleFunc("/api/scriptum", gzipHandler(codex.apiScriptumHandler))
+	router.HandleFunc("/api/scriptum/{slug}", gzipHandler(codex.apiScriptumHandler))
+	router.HandleFunc("/api/album", gzipHandler(codex.apiAlbumHandler))
+	router.HandleFunc("/api/album/{fileName}", gzipHandler(codex.apiAlbumHandler))
 
 	router.HandleFunc("/profile/", gzipHandler(func(w http.ResponseWriter, r *http.Request) {
-		profileHandler(w, r, profileTmpl)
+		profileHandler(w, r, profileTmpl.Load())
 	}))
 
-	slog.Info("Server running on " + "http://" + conn)
-	log.Fatal(http.ListenAndServe(conn, router))
+	var handler http.Handler = router
+	if os.Getenv("DEV_MODE") != "" {
+		dev := newDevReloader(
+			[]string{"templates", "profile", "scriptum", galleryPath},
+			func() error {
+				tmpl, err := parseProfileTemplates()
+				if err != nil {
+					return err
+				}
+				profileTmpl.Store(tmpl)
+				return nil
+			},
+			scriptum.reloadStrict,
+			codex.reload,
+			gallery.reloadTemplates,
+		)
+		go dev.run(ctx)
+		handler = dev.middleware(router)
+	}
+
+	server := &http.Server{Addr: conn, Handler: handler}
+	go func() {
+		slog.Info("Server running on " + "http://" + conn)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down server", "err", err)
+	}
+
+	select {
+	case <-schedulerDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("Sync still running at shutdown")
+	}
+}
+
+// durationEnv parses an environment variable as a time.Duration, or returns
+// def when it's unset.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return d, nil
 }
 
 func gzipHandler(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
 		if !acceptsGzip(r) {
 			handler(w, r)
 			return
@@ -148,52 +275,3 @@ type gzipResponseWriter struct {
 func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
-
-func updateHandler(w http.ResponseWriter, r *http.Request, g *Gallery) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	remoteProfile := os.Getenv("REMOTE_PROFILE_URL")
-	if remoteProfile == "" {
-		remoteProfile = profileFallback
-	}
-
-	res, err := http.Get(remoteProfile)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("Error getting remote profile object: " + err.Error())
-		return
-	}
-
-	defer res.Body.Close()
-
-	b, err := io.ReadAll(res.Body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("Error reading profile object: " + err.Error())
-		return
-	}
-
-	if !json.Valid([]byte(b)) {
-		http.Redirect(w, r, "/", http.StatusFound)
-		slog.Warn("Invalid JSON found when updating profile")
-		return
-	}
-
-	err = os.WriteFile("./profile.json", b, 0o644)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("Error writing profile object to disk: " + err.Error())
-		return
-	}
-
-	slog.Info("Updated profile, request from " + r.RemoteAddr)
-
-	if err := updateGallery(g); err != nil {
-		slog.Error("Failed to update gallery:", "err", err)
-	}
-
-	http.Redirect(w, r, "/", http.StatusFound)
-}
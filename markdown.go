@@ -0,0 +1,734 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// renderMarkdown renders a CommonMark subset: ATX and setext headings,
+// paragraphs, bullet and ordered lists, fenced and indented code blocks,
+// blockquotes, thematic breaks, and inline emphasis, code spans, links,
+// images, autolinks and hard line breaks. Reference links and raw HTML are
+// not supported, the latter is escaped like any other text.
+func renderMarkdown(src string, _ int) (template.HTML, error) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+
+	var b strings.Builder
+	mdBlocks(&b, strings.Split(src, "\n"), false)
+	return template.HTML(b.String()), nil
+}
+
+// mdBlocks renders lines as a sequence of blocks. Paragraphs of tight list
+// items are written without <p> tags.
+func mdBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+
+		if strings.TrimSpace(line) == "" {
+			i++
+			continue
+		}
+
+		if indent >= 4 {
+			var code []string
+			for ; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) != "" && mdIndent(lines[i]) < 4 {
+					break
+				}
+				code = append(code, mdDedent(lines[i], 4))
+			}
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+			continue
+		}
+
+		if fence, info, ok := mdFence(trimmed); ok {
+			var code []string
+			for i++; i < len(lines); i++ {
+				if t := strings.TrimSpace(lines[i]); strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+					i++
+					break
+				}
+				code = append(code, mdDedent(lines[i], indent))
+			}
+
+			b.WriteString("<pre><code")
+			if lang, _, _ := strings.Cut(info, " "); lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+			}
+			b.WriteString(">")
+			if len(code) > 0 {
+				b.WriteString(html.EscapeString(strings.Join(code, "\n")) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+			continue
+		}
+
+		if level, text, ok := mdHeading(trimmed); ok {
+			tag := "h" + strconv.Itoa(level)
+			b.WriteString("<" + tag + ">" + mdInline(text) + "</" + tag + ">\n")
+			i++
+			continue
+		}
+
+		if mdBreak(trimmed) {
+			b.WriteString("<hr />\n")
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			var quote []string
+			for ; i < len(lines); i++ {
+				t := strings.TrimLeft(lines[i], " ")
+				if !strings.HasPrefix(t, ">") {
+					break
+				}
+				t = strings.TrimPrefix(t[1:], " ")
+				quote = append(quote, t)
+			}
+			b.WriteString("<blockquote>\n")
+			mdBlocks(b, quote, false)
+			b.WriteString("</blockquote>\n")
+			continue
+		}
+
+		if item, ok := mdListItem(line); ok {
+			i = mdList(b, lines, i, item)
+			continue
+		}
+
+		var para []string
+		for ; i < len(lines); i++ {
+			l := lines[i]
+			t := strings.TrimLeft(l, " ")
+			if strings.TrimSpace(l) == "" {
+				break
+			}
+			if len(para) > 0 {
+				if level, ok := mdSetext(t); ok && mdIndent(l) < 4 {
+					tag := "h" + strconv.Itoa(level)
+					b.WriteString("<" + tag + ">" + mdInline(mdJoin(para)) + "</" + tag + ">\n")
+					para = nil
+					i++
+					break
+				}
+				if mdInterrupts(t) {
+					break
+				}
+			}
+			para = append(para, t)
+		}
+		if len(para) == 0 {
+			continue
+		}
+
+		if tight {
+			b.WriteString(mdInline(mdJoin(para)))
+		} else {
+			b.WriteString("<p>" + mdInline(mdJoin(para)) + "</p>\n")
+		}
+	}
+}
+
+type mdItem struct {
+	ordered bool
+	marker  byte // -, + or * for bullets, . or ) for ordered lists
+	start   int
+	content int // column where the item's content starts
+}
+
+// mdList renders the list starting at lines[i], returning the index of the
+// first line after it.
+func mdList(b *strings.Builder, lines []string, i int, first mdItem) int {
+	var items [][]string
+	loose := false
+
+	for i < len(lines) {
+		item, ok := mdListItem(lines[i])
+		if !ok || item.ordered != first.ordered || item.marker != first.marker {
+			break
+		}
+		if len(items) > 0 && strings.TrimSpace(lines[i-1]) == "" {
+			loose = true
+		}
+
+		body := []string{lines[i][min(item.content, len(lines[i])):]}
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if mdBlank(l) {
+				body = append(body, "")
+				continue
+			}
+			if mdIndented(l, item.content) {
+				if body[len(body)-1] == "" && len(body) > 1 {
+					loose = true
+				}
+				body = append(body, mdDedent(l, item.content))
+				continue
+			}
+			// Lazy continuation of the item's last paragraph.
+			if body[len(body)-1] != "" && !mdInterrupts(strings.TrimLeft(l, " ")) {
+				if _, ok := mdListItem(l); !ok {
+					body = append(body, strings.TrimLeft(l, " "))
+					continue
+				}
+			}
+			break
+		}
+
+		for len(body) > 0 && body[len(body)-1] == "" {
+			body = body[:len(body)-1]
+		}
+		items = append(items, body)
+	}
+
+	if first.ordered {
+		b.WriteString("<ol")
+		if first.start != 1 {
+			b.WriteString(` start="` + strconv.Itoa(first.start) + `"`)
+		}
+		b.WriteString(">\n")
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for _, body := range items {
+		b.WriteString("<li>")
+		mdBlocks(b, body, !loose)
+		b.WriteString("</li>\n")
+	}
+
+	if first.ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+// mdListItem parses a list marker such as "- ", "* ", "1. " or "2) ".
+func mdListItem(line string) (mdItem, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	if indent >= 4 || trimmed == "" {
+		return mdItem{}, false
+	}
+
+	item := mdItem{}
+	var rest string
+
+	switch c := trimmed[0]; {
+	case c == '-' || c == '+' || c == '*':
+		if mdBreak(trimmed) {
+			return mdItem{}, false
+		}
+		item.marker = c
+		rest = trimmed[1:]
+	case c >= '0' && c <= '9':
+		n := 0
+		for n < len(trimmed) && n < 9 && trimmed[n] >= '0' && trimmed[n] <= '9' {
+			n++
+		}
+		if n == len(trimmed) || (trimmed[n] != '.' && trimmed[n] != ')') {
+			return mdItem{}, false
+		}
+		item.ordered = true
+		item.marker = trimmed[n]
+		item.start, _ = strconv.Atoi(trimmed[:n])
+		rest = trimmed[n+1:]
+	default:
+		return mdItem{}, false
+	}
+
+	markerWidth := len(trimmed) - len(rest)
+	if rest == "" {
+		item.content = indent + markerWidth + 1
+		return item, true
+	}
+	if rest[0] != ' ' {
+		return mdItem{}, false
+	}
+
+	spaces := len(rest) - len(strings.TrimLeft(rest, " "))
+	if spaces > 4 {
+		spaces = 1
+	}
+	item.content = indent + markerWidth + spaces
+	return item, true
+}
+
+// mdInterrupts reports whether a line starts a block that ends a paragraph.
+func mdInterrupts(trimmed string) bool {
+	if _, _, ok := mdHeading(trimmed); ok {
+		return true
+	}
+	if _, _, ok := mdFence(trimmed); ok {
+		return true
+	}
+	if mdBreak(trimmed) || strings.HasPrefix(trimmed, ">") {
+		return true
+	}
+	if item, ok := mdListItem(trimmed); ok {
+		// Only non-empty items, and ordered ones starting at 1, interrupt.
+		return strings.TrimSpace(trimmed[min(item.content, len(trimmed)):]) != "" && (!item.ordered || item.start == 1)
+	}
+	return false
+}
+
+func mdHeading(trimmed string) (int, string, bool) {
+	level := 0
+	for level < len(trimmed) && trimmed[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+
+	rest := trimmed[level:]
+	if rest != "" && rest[0] != ' ' {
+		return 0, "", false
+	}
+
+	text := strings.TrimSpace(rest)
+	if closed := strings.TrimRight(text, "#"); closed == "" || strings.HasSuffix(closed, " ") {
+		text = strings.TrimSpace(closed)
+	}
+	return level, text, true
+}
+
+func mdSetext(trimmed string) (int, bool) {
+	t := strings.TrimRight(trimmed, " ")
+	switch {
+	case t != "" && strings.Trim(t, "=") == "":
+		return 1, true
+	case t != "" && strings.Trim(t, "-") == "":
+		return 2, true
+	}
+	return 0, false
+}
+
+func mdBreak(trimmed string) bool {
+	t := strings.ReplaceAll(trimmed, " ", "")
+	if len(t) < 3 {
+		return false
+	}
+	return strings.Trim(t, t[:1]) == "" && strings.ContainsAny(t[:1], "-*_")
+}
+
+func mdFence(trimmed string) (string, string, bool) {
+	for _, c := range []string{"`", "~"} {
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, c))
+		if n < 3 {
+			continue
+		}
+		info := strings.TrimSpace(trimmed[n:])
+		if c == "`" && strings.Contains(info, "`") {
+			return "", "", false
+		}
+		return trimmed[:n], info, true
+	}
+	return "", "", false
+}
+
+func mdIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// mdIndented reports whether line starts with n spaces. Like mdDedent and
+// mdBlank, it only looks at as much of the line as it needs to, so nested
+// lists don't rescan the indentation of every line at every level.
+func mdIndented(line string, n int) bool {
+	if len(line) < n {
+		return false
+	}
+	for i := range n {
+		if line[i] != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// mdDedent removes up to n leading spaces.
+func mdDedent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
+}
+
+func mdBlank(line string) bool {
+	return strings.TrimRight(line, " ") == ""
+}
+
+// mdJoin joins paragraph lines, turning trailing double spaces into the
+// backslash form of a hard line break.
+func mdJoin(lines []string) string {
+	for i := range len(lines) - 1 {
+		if strings.HasSuffix(lines[i], "  ") {
+			lines[i] = strings.TrimRight(lines[i], " ") + "\\"
+		}
+	}
+	lines[len(lines)-1] = strings.TrimRight(lines[len(lines)-1], " ")
+	return strings.Join(lines, "\n")
+}
+
+const mdPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// mdDelim is a run of * or _ that may open or close emphasis. Openers use
+// their rightmost characters for a match and closers their leftmost ones;
+// characters left unmatched are written as text.
+type mdDelim struct {
+	c                 byte
+	n                 int
+	canOpen, canClose bool
+	opens, closes     []string
+}
+
+type mdNode struct {
+	html  string
+	delim *mdDelim
+}
+
+// mdInline renders inline markup, escaping everything else.
+func mdInline(s string) string {
+	var b strings.Builder
+	var nodes []mdNode
+	var delims []*mdDelim
+
+	for i := 0; i < len(s); {
+		next := strings.IndexAny(s[i:], "\\`![<*_")
+		if next < 0 {
+			b.WriteString(html.EscapeString(s[i:]))
+			break
+		}
+		b.WriteString(html.EscapeString(s[i : i+next]))
+		i += next
+
+		switch c := s[i]; c {
+		case '\\':
+			switch {
+			case i+1 < len(s) && s[i+1] == '\n':
+				b.WriteString("<br />\n")
+				i += 2
+			case i+1 < len(s) && strings.IndexByte(mdPunct, s[i+1]) >= 0:
+				b.WriteString(html.EscapeString(s[i+1 : i+2]))
+				i += 2
+			default:
+				b.WriteString("\\")
+				i++
+			}
+
+		case '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			end := mdCodeEnd(s, i+n, n)
+			if end < 0 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+			code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i = end + n
+
+		case '!', '[':
+			image := c == '!'
+			at := i
+			if image {
+				if i+1 >= len(s) || s[i+1] != '[' {
+					b.WriteString("!")
+					i++
+					continue
+				}
+				at++
+			}
+
+			text, dest, title, end, ok := mdLink(s, at)
+			if !ok {
+				b.WriteString(s[i : at+1])
+				i = at + 1
+				continue
+			}
+			i = end
+
+			safe := mdSafeURL(dest)
+			switch {
+			case image && safe:
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(mdPlain(text)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+			case image:
+				b.WriteString(html.EscapeString(mdPlain(text)))
+			case safe:
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + mdInline(text) + "</a>")
+			default:
+				b.WriteString(mdInline(text))
+			}
+
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			target := ""
+			if end > 0 {
+				target = s[i+1 : i+end]
+			}
+			isURL := strings.Contains(target, "://") && mdSafeURL(target)
+			isMail := strings.Count(target, "@") == 1 && !strings.Contains(target, ":")
+			if target == "" || strings.ContainsAny(target, " \n<") || (!isURL && !isMail) {
+				b.WriteString("&lt;")
+				i++
+				continue
+			}
+			href := target
+			if isMail {
+				href = "mailto:" + target
+			}
+			b.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(target) + "</a>")
+			i += end + 1
+
+		case '*', '_':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+			before, after := byte(' '), byte(' ')
+			if i > 0 {
+				before = s[i-1]
+			}
+			if i+n < len(s) {
+				after = s[i+n]
+			}
+
+			d := &mdDelim{
+				c:        c,
+				n:        n,
+				canOpen:  !mdSpace(after) && !(c == '_' && mdWord(before)),
+				canClose: !mdSpace(before) && !(c == '_' && mdWord(after)),
+			}
+			nodes = append(nodes, mdNode{html: b.String()}, mdNode{delim: d})
+			delims = append(delims, d)
+			b.Reset()
+			i += n
+		}
+	}
+	nodes = append(nodes, mdNode{html: b.String()})
+
+	mdEmphasis(delims)
+
+	var out strings.Builder
+	for _, node := range nodes {
+		d := node.delim
+		if d == nil {
+			out.WriteString(node.html)
+			continue
+		}
+		for _, tag := range d.closes {
+			out.WriteString("</" + tag + ">")
+		}
+		out.WriteString(strings.Repeat(string(d.c), d.n))
+		for _, tag := range slices.Backward(d.opens) {
+			out.WriteString("<" + tag + ">")
+		}
+	}
+	return out.String()
+}
+
+// mdCodeEnd finds the closing backtick run of exactly n backticks.
+func mdCodeEnd(s string, from, n int) int {
+	for i := from; i < len(s); {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// mdEmphasis matches delimiter runs in a single pass with a stack of
+// openers. A closer pairs with the nearest opener of its kind, dropping the
+// openers above it, so every opener is pushed and popped once.
+func mdEmphasis(delims []*mdDelim) {
+	var openers []*mdDelim
+	open := map[byte]int{}
+
+	for _, d := range delims {
+		for d.canClose && d.n > 0 && open[d.c] > 0 {
+			k := len(openers) - 1
+			for openers[k].c != d.c {
+				open[openers[k].c]--
+				k--
+			}
+			o := openers[k]
+
+			use, tag := 1, "em"
+			if o.n >= 2 && d.n >= 2 {
+				use, tag = 2, "strong"
+			}
+			o.n -= use
+			d.n -= use
+			o.opens = append(o.opens, tag)
+			d.closes = append(d.closes, tag)
+
+			openers = openers[:k+1]
+			if o.n == 0 {
+				openers = openers[:k]
+				open[d.c]--
+			}
+		}
+
+		if d.canOpen && d.n > 0 {
+			openers = append(openers, d)
+			open[d.c]++
+		}
+	}
+}
+
+// mdLink parses [text](destination "title") with the [ at s[i].
+func mdLink(s string, i int) (text, dest, title string, end int, ok bool) {
+	depth := 0
+	close := -1
+	for j := i; j < len(s) && close < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			run := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+			if e := mdCodeEnd(s, j+run, run); e >= 0 {
+				j = e + run - 1
+			} else {
+				j += run - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				close = j
+			}
+		}
+	}
+	if close < 0 || close+1 >= len(s) || s[close+1] != '(' {
+		return
+	}
+	text = s[i+1 : close]
+
+	j := close + 2
+	skip := func() {
+		for j < len(s) && mdSpace(s[j]) {
+			j++
+		}
+	}
+	skip()
+
+	if j < len(s) && s[j] == '<' {
+		e := strings.IndexAny(s[j+1:], ">\n")
+		if e < 0 || s[j+1+e] != '>' {
+			return
+		}
+		dest = s[j+1 : j+1+e]
+		j += e + 2
+	} else {
+		start, parens := j, 0
+		for ; j < len(s) && !mdSpace(s[j]); j++ {
+			if s[j] == '\\' {
+				j++
+			} else if s[j] == '(' {
+				parens++
+			} else if s[j] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = s[start:min(j, len(s))]
+	}
+
+	hadSpace := j < len(s) && mdSpace(s[j])
+	skip()
+	if j < len(s) && hadSpace && strings.IndexByte("\"'(", s[j]) >= 0 {
+		closing := s[j]
+		if closing == '(' {
+			closing = ')'
+		}
+		e := strings.IndexByte(s[j+1:], closing)
+		if e < 0 {
+			return
+		}
+		title = mdUnescape(s[j+1 : j+1+e])
+		j += e + 2
+		skip()
+	}
+
+	if j >= len(s) || s[j] != ')' {
+		return
+	}
+
+	return text, mdUnescape(dest), title, j + 1, true
+}
+
+func mdUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(mdPunct, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mdPlain strips inline markup for alt text.
+func mdPlain(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("*_`[]", r) {
+			return -1
+		}
+		return r
+	}, mdUnescape(s))
+}
+
+// mdSafeURL allows relative URLs and http(s) and mailto ones, keeping
+// javascript: and data: URLs out of posts.
+func mdSafeURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func mdSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+func mdWord(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
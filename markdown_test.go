@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"paragraph", "one\ntwo", "<p>one\ntwo</p>\n"},
+		{"atx heading", "## Title ##", "<h2>Title</h2>\n"},
+		{"setext heading", "Title\n---", "<h2>Title</h2>\n"},
+		{"emphasis", "*a* **b** _c_", "<p><em>a</em> <strong>b</strong> <em>c</em></p>\n"},
+		{"nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"strong and em", "***a***", "<p><em><strong>a</strong></em></p>\n"},
+		{"extra opener", "**a*", "<p>*<em>a</em></p>\n"},
+		{"crossed markers", "*a _b* c_", "<p><em>a _b</em> c_</p>\n"},
+		{"unclosed", "*a _b", "<p>*a _b</p>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"spaced star", "5 * 3 * 2", "<p>5 * 3 * 2</p>\n"},
+		{"code span", "`*a* <b>`", "<p><code>*a* &lt;b&gt;</code></p>\n"},
+		{"escape", `\*a\*`, "<p>*a*</p>\n"},
+		{"hard break", "a  \nb", "<p>a<br />\nb</p>\n"},
+		{"link", `[a *b*](https://example.com "T")`, `<p><a href="https://example.com" title="T">a <em>b</em></a></p>` + "\n"},
+		{"image", "![a *b*](/static/a.webp)", `<p><img src="/static/a.webp" alt="a b"></p>` + "\n"},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com">https://example.com</a></p>` + "\n"},
+		{"email autolink", "<a@example.com>", `<p><a href="mailto:a@example.com">a@example.com</a></p>` + "\n"},
+		{"bullet list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"loose list", "- a\n\n- b", "<ul>\n<li><p>a</p>\n</li>\n<li><p>b</p>\n</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"nested list", "- a\n  - b", "<ul>\n<li>a<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n"},
+		{"blockquote", "> a\n> b", "<blockquote>\n<p>a\nb</p>\n</blockquote>\n"},
+		{"fenced code", "```go\nx := 1 < 2\n```", `<pre><code class="language-go">x := 1 &lt; 2` + "\n</code></pre>\n"},
+		{"indented code", "    <b>", "<pre><code>&lt;b&gt;\n</code></pre>\n"},
+		{"thematic break", "***", "<hr />\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdown(tt.src, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderMarkdown(%q)\n got %q\nwant %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRenderMarkdownUnsafe checks that posts can't inject markup: raw HTML is
+// escaped and links to javascript: or data: URLs are rendered as text.
+func TestRenderMarkdownUnsafe(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, want string
+	}{
+		{"raw html", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"html block", "<div onclick=\"x\">\n</div>", "<p>&lt;div onclick=&#34;x&#34;&gt;\n&lt;/div&gt;</p>\n"},
+		{"javascript link", "[a](javascript:alert(1))", "<p>a</p>\n"},
+		{"javascript link in caps", "[a](JavaScript:alert(1))", "<p>a</p>\n"},
+		{"data image", "![a](data:image/svg+xml,x)", "<p>a</p>\n"},
+		{"javascript autolink", "<javascript://x>", "<p>&lt;javascript://x&gt;</p>\n"},
+		{"quote in href", `[a](/x"onmouseover="y)`, `<p><a href="/x&#34;onmouseover=&#34;y">a</a></p>` + "\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdown(tt.src, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderMarkdown(%q)\n got %q\nwant %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRenderMarkdownNesting renders inputs that used to take seconds: long
+// runs of unclosed emphasis and deeply nested lists.
+func TestRenderMarkdownNesting(t *testing.T) {
+	if _, err := renderMarkdown(strings.Repeat("*a _b ", 20000), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var list strings.Builder
+	for i := range 2000 {
+		list.WriteString(strings.Repeat("  ", i) + "- a\n")
+	}
+	got, err := renderMarkdown(list.String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(got), "<ul>"); n != 2000 {
+		t.Errorf("got %d lists, want 2000", n)
+	}
+}
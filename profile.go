@@ -3,11 +3,14 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 var profileCache atomic.Value
@@ -78,6 +81,45 @@ func (p *Profile) FromJson(path string) (*Profile, error) {
 	return p, nil
 }
 
+// parseProfile decodes and validates a profile, then renders it with tmpl to
+// make sure it will actually be served, including the Render flag.
+func parseProfile(b []byte, tmpl *template.Template) (*Profile, error) {
+	p, err := validateProfileJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "index", p); err != nil {
+		return nil, fmt.Errorf("profile doesn't render: %w", err)
+	}
+	if rendered := strings.Contains(buf.String(), `id="render"`); rendered != p.Render {
+		return nil, fmt.Errorf("profile has render=%t but the template disagrees", p.Render)
+	}
+
+	return p, nil
+}
+
+// reloadProfile swaps the served profile for a new one. An invalid profile
+// leaves the current one in place.
+func reloadProfile(b []byte, tmpl *template.Template) error {
+	p, err := parseProfile(b, tmpl)
+	if err != nil {
+		return err
+	}
+
+	profileCache.Store(p)
+	return nil
+}
+
+func parseProfileTemplates() (*template.Template, error) {
+	tmpl, err := template.ParseGlob("profile/*.go.html")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing profile templates: %w", err)
+	}
+	return tmpl, nil
+}
+
 func getProfile() (Profile, error) {
 	if p, ok := profileCache.Load().(*Profile); ok {
 		return *p, nil
@@ -115,13 +157,7 @@ func profileHandler(w http.ResponseWriter, r *http.Request, tmpl *template.Templ
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-	_, err = buf.WriteTo(w)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("Error writing html to buffer: " + err.Error())
-		return
-	}
+	writeHTML(w, r, &buf, time.Time{})
 }
 
 func profileErrorHandler(w http.ResponseWriter, r *http.Request, status int, tmpl *template.Template) {
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+)
+
+// .rio v2 layout (all integers little endian):
+//
+//	magic      [4]byte  "\x89RIO"
+//	version    uint16   2
+//	count      uint16   number of renditions
+//	metaLen    uint32
+//	metaSum    [32]byte SHA-256 of the metadata JSON
+//	count times:
+//	  nameLen  uint8, name
+//	  mimeLen  uint8, mime
+//	  width    uint32
+//	  height   uint32
+//	  offset   uint64   absolute offset of the payload
+//	  length   uint32
+//	  sum      [32]byte SHA-256 of the payload
+//	headerCRC  uint32   CRC-32 (IEEE) of every byte above
+//	metadata JSON
+//	payloads
+//
+// v1 files have no header: uint32 meta length, JSON, uint32 image length, bytes.
+// The magic can't be mistaken for a v1 meta length, since it would mean ~1.3 GB
+// of JSON.
+const (
+	rioVersion = 2
+
+	rioFull  = "full"
+	rioThumb = "thumb"
+
+	rioMaxMeta = 1 << 20
+)
+
+var rioMagic = [4]byte{0x89, 'R', 'I', 'O'}
+
+var (
+	errRioTruncated = errors.New("rio: file is truncated")
+	errRioChecksum  = errors.New("rio: checksum mismatch")
+	errRioVersion   = errors.New("rio: unsupported version")
+	errRioNoRender  = errors.New("rio: rendition not found")
+)
+
+type rioFile struct {
+	Version    int
+	Meta       Image
+	Renditions []rioRendition
+}
+
+type rioRendition struct {
+	Name   string
+	MIME   string
+	Width  uint32
+	Height uint32
+	Offset int64
+	Length int64
+	Sum    [sha256.Size]byte
+	hasSum bool
+}
+
+func (rf *rioFile) rendition(name string) (rioRendition, error) {
+	for _, r := range rf.Renditions {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return rioRendition{}, fmt.Errorf("%w: %q", errRioNoRender, name)
+}
+
+// readRio parses the header and metadata of a .rio file of the given size.
+// Payloads are bounds-checked but not read; use readRendition for that.
+func readRio(r io.ReaderAt, size int64) (*rioFile, error) {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, errRioTruncated
+	}
+
+	if magic != rioMagic {
+		return readRioV1(r, size)
+	}
+
+	return readRioV2(r, size)
+}
+
+func readRioV1(r io.ReaderAt, size int64) (*rioFile, error) {
+	sr := io.NewSectionReader(r, 0, size)
+
+	var metaLen uint32
+	if err := binary.Read(sr, binary.LittleEndian, &metaLen); err != nil {
+		return nil, errRioTruncated
+	}
+	if metaLen > rioMaxMeta || int64(metaLen)+8 > size {
+		return nil, fmt.Errorf("%w: metadata length %d exceeds file size %d", errRioTruncated, metaLen, size)
+	}
+
+	metaBytes := make([]byte, metaLen)
+	if _, err := io.ReadFull(sr, metaBytes); err != nil {
+		return nil, errRioTruncated
+	}
+
+	var meta Image
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, fmt.Errorf("rio: invalid metadata: %w", err)
+	}
+
+	var imgLen uint32
+	if err := binary.Read(sr, binary.LittleEndian, &imgLen); err != nil {
+		return nil, errRioTruncated
+	}
+
+	offset := int64(metaLen) + 8
+	if offset+int64(imgLen) > size {
+		return nil, fmt.Errorf("%w: image section needs %d bytes, file has %d", errRioTruncated, offset+int64(imgLen), size)
+	}
+
+	full := rioRendition{Name: rioFull, Offset: offset, Length: int64(imgLen)}
+	full.MIME = sniffRendition(r, full)
+
+	return &rioFile{Version: 1, Meta: meta, Renditions: []rioRendition{full}}, nil
+}
+
+func readRioV2(r io.ReaderAt, size int64) (*rioFile, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	crc := crc32.NewIEEE()
+	hr := io.TeeReader(sr, crc)
+
+	var fixed struct {
+		Magic   [4]byte
+		Version uint16
+		Count   uint16
+		MetaLen uint32
+		MetaSum [sha256.Size]byte
+	}
+	if err := binary.Read(hr, binary.LittleEndian, &fixed); err != nil {
+		return nil, errRioTruncated
+	}
+	if fixed.Version != rioVersion {
+		return nil, fmt.Errorf("%w: %d", errRioVersion, fixed.Version)
+	}
+	if fixed.Count == 0 {
+		return nil, errors.New("rio: file has no renditions")
+	}
+	if fixed.MetaLen > rioMaxMeta {
+		return nil, fmt.Errorf("rio: metadata length %d is too large", fixed.MetaLen)
+	}
+
+	renditions := make([]rioRendition, 0, fixed.Count)
+	for range fixed.Count {
+		name, err := readRioString(hr)
+		if err != nil {
+			return nil, err
+		}
+		mime, err := readRioString(hr)
+		if err != nil {
+			return nil, err
+		}
+
+		var entry struct {
+			Width  uint32
+			Height uint32
+			Offset uint64
+			Length uint32
+			Sum    [sha256.Size]byte
+		}
+		if err := binary.Read(hr, binary.LittleEndian, &entry); err != nil {
+			return nil, errRioTruncated
+		}
+
+		if entry.Offset > uint64(size) || int64(entry.Offset)+int64(entry.Length) > size {
+			return nil, fmt.Errorf("%w: rendition %q ends past end of file", errRioTruncated, name)
+		}
+
+		renditions = append(renditions, rioRendition{
+			Name:   name,
+			MIME:   mime,
+			Width:  entry.Width,
+			Height: entry.Height,
+			Offset: int64(entry.Offset),
+			Length: int64(entry.Length),
+			Sum:    entry.Sum,
+			hasSum: true,
+		})
+	}
+
+	sum := crc.Sum32()
+	var headerCRC uint32
+	if err := binary.Read(sr, binary.LittleEndian, &headerCRC); err != nil {
+		return nil, errRioTruncated
+	}
+	if headerCRC != sum {
+		return nil, fmt.Errorf("%w: header", errRioChecksum)
+	}
+
+	metaBytes := make([]byte, fixed.MetaLen)
+	if _, err := io.ReadFull(sr, metaBytes); err != nil {
+		return nil, errRioTruncated
+	}
+	if sha256.Sum256(metaBytes) != fixed.MetaSum {
+		return nil, fmt.Errorf("%w: metadata", errRioChecksum)
+	}
+
+	var meta Image
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, fmt.Errorf("rio: invalid metadata: %w", err)
+	}
+
+	return &rioFile{Version: rioVersion, Meta: meta, Renditions: renditions}, nil
+}
+
+func readRioString(r io.Reader) (string, error) {
+	var n uint8
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", errRioTruncated
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errRioTruncated
+	}
+	return string(b), nil
+}
+
+func sniffRendition(r io.ReaderAt, rend rioRendition) string {
+	head := make([]byte, min(rend.Length, 512))
+	n, _ := r.ReadAt(head, rend.Offset)
+	return http.DetectContentType(head[:n])
+}
+
+// readRendition reads a rendition payload and verifies its checksum.
+func readRendition(r io.ReaderAt, rend rioRendition) ([]byte, error) {
+	b := make([]byte, rend.Length)
+	if n, _ := r.ReadAt(b, rend.Offset); int64(n) < rend.Length {
+		return nil, errRioTruncated
+	}
+
+	if rend.hasSum && sha256.Sum256(b) != rend.Sum {
+		return nil, fmt.Errorf("%w: rendition %q", errRioChecksum, rend.Name)
+	}
+
+	return b, nil
+}
+
+// openRio opens and parses a .rio file. The caller must close the returned file.
+func openRio(path string) (*os.File, *rioFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	rf, err := readRio(f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return f, rf, nil
+}
+
+// verifyRio checks that the file at path is a complete .rio file whose
+// sections all match their checksums.
+func verifyRio(path string) error {
+	f, rf, err := openRio(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, rend := range rf.Renditions {
+		if _, err := readRendition(f, rend); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
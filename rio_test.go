@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testdata/sample.rio is a 16x12 PNG encoded by the gallery encoder with an
+// 8px thumbnail:
+//
+//	go run ./gallery -o testdata -thumb-width 8 <dir with sample.png> meta.json
+func readSample(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("testdata/sample.rio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func encodeRioV1(t *testing.T, meta Image, img []byte) []byte {
+	t.Helper()
+	m, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(m)))
+	b.Write(m)
+	binary.Write(&b, binary.LittleEndian, uint32(len(img)))
+	b.Write(img)
+	return b.Bytes()
+}
+
+func TestReadRioV1(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n not really a png")
+	meta := Image{Filename: "a.png", Title: "A", Date: "2025-01-02"}
+	data := encodeRioV1(t, meta, png)
+
+	rf, err := readRio(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.Version != 1 || rf.Meta != meta {
+		t.Fatalf("got version %d meta %+v", rf.Version, rf.Meta)
+	}
+
+	full, err := rf.rendition(rioFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.MIME != "image/png" {
+		t.Errorf("MIME = %q, want image/png", full.MIME)
+	}
+	got, err := readRendition(bytes.NewReader(data), full)
+	if err != nil || !bytes.Equal(got, png) {
+		t.Errorf("readRendition = %q, %v", got, err)
+	}
+	if _, err := rf.rendition(rioThumb); !errors.Is(err, errRioNoRender) {
+		t.Errorf("thumb rendition err = %v, want %v", err, errRioNoRender)
+	}
+
+	if _, err := readRio(bytes.NewReader(data[:len(data)-1]), int64(len(data)-1)); !errors.Is(err, errRioTruncated) {
+		t.Errorf("truncated v1 err = %v, want %v", err, errRioTruncated)
+	}
+}
+
+func TestReadRioV2(t *testing.T) {
+	data := readSample(t)
+
+	rf, err := readRio(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Image{Filename: "sample.png", Title: "Sample", Description: "A gradient.", Date: "2025-03-14"}
+	if rf.Version != rioVersion || rf.Meta != want {
+		t.Fatalf("got version %d meta %+v", rf.Version, rf.Meta)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		mime          string
+		width, height uint32
+	}{
+		{rioFull, "image/png", 16, 12},
+		{rioThumb, "image/jpeg", 8, 6},
+	} {
+		rend, err := rf.rendition(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rend.MIME != tt.mime || rend.Width != tt.width || rend.Height != tt.height {
+			t.Errorf("%s: got %s %dx%d, want %s %dx%d", tt.name, rend.MIME, rend.Width, rend.Height, tt.mime, tt.width, tt.height)
+		}
+		if _, err := readRendition(bytes.NewReader(data), rend); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestReadRioV2Corrupt(t *testing.T) {
+	sample := readSample(t)
+	metaStart := bytes.Index(sample, []byte(`{"filename"`))
+
+	for _, tt := range []struct {
+		name   string
+		mutate func([]byte) []byte
+		want   error
+	}{
+		{"truncated header", func(b []byte) []byte { return b[:20] }, errRioTruncated},
+		{"truncated payload", func(b []byte) []byte { return b[:len(b)-1] }, errRioTruncated},
+		{"header crc", func(b []byte) []byte { b[0x3b] ^= 1; return b }, errRioChecksum},
+		{"metadata sum", func(b []byte) []byte { b[metaStart+2] ^= 1; return b }, errRioChecksum},
+		{"wrong version", func(b []byte) []byte { b[4] = rioVersion + 1; return b }, errRioVersion},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(bytes.Clone(sample))
+			_, err := readRio(bytes.NewReader(data), int64(len(data)))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRenditionPayloadSum(t *testing.T) {
+	data := readSample(t)
+	data[len(data)-1] ^= 1 // the thumbnail is the last payload
+
+	rf, err := readRio(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, _ := rf.rendition(rioFull)
+	if _, err := readRendition(bytes.NewReader(data), full); err != nil {
+		t.Errorf("full: %v", err)
+	}
+	thumb, _ := rf.rendition(rioThumb)
+	if _, err := readRendition(bytes.NewReader(data), thumb); !errors.Is(err, errRioChecksum) {
+		t.Errorf("thumb err = %v, want %v", err, errRioChecksum)
+	}
+
+	path := t.TempDir() + "/bad.rio"
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRio(path); !errors.Is(err, errRioChecksum) {
+		t.Errorf("verifyRio err = %v, want %v", err, errRioChecksum)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scheduler periodically re-syncs the remote profile and the gallery, so the
+// site stays fresh without anyone hitting /update/. Both syncs use
+// conditional requests, so runs where nothing changed are cheap.
+type scheduler struct {
+	interval time.Duration
+	jitter   time.Duration
+	gallery  *Gallery
+	tmpl     *atomic.Pointer[template.Template]
+
+	mu     sync.Mutex
+	status syncStatus
+}
+
+type syncStatus struct {
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+func newScheduler(g *Gallery, profileTmpl *atomic.Pointer[template.Template], interval, jitter time.Duration) *scheduler {
+	return &scheduler{interval: interval, jitter: jitter, gallery: g, tmpl: profileTmpl}
+}
+
+// run syncs every interval, plus or minus a random jitter, until ctx is done.
+// A sync in progress when ctx is cancelled is allowed to finish.
+func (s *scheduler) run(ctx context.Context) {
+	slog.Info("Sync scheduler started", "interval", s.interval, "jitter", s.jitter)
+
+	timer := time.NewTimer(s.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Sync scheduler stopped")
+			return
+		case <-timer.C:
+			s.sync()
+			timer.Reset(s.next())
+		}
+	}
+}
+
+func (s *scheduler) next() time.Duration {
+	if s.jitter <= 0 {
+		return s.interval
+	}
+	d := s.interval + time.Duration(rand.Int63n(int64(2*s.jitter))) - s.jitter
+	return max(d, time.Second)
+}
+
+func (s *scheduler) sync() {
+	summary := runUpdate(s.gallery, s.tmpl.Load())
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.LastRun = now
+	if len(summary.Errors) > 0 {
+		s.status.LastFailure = now
+		s.status.LastError = summary.Errors[0]
+		slog.Warn("Scheduled sync failed", "errors", summary.Errors, "lastSuccess", s.status.LastSuccess)
+		return
+	}
+
+	s.status.LastSuccess = now
+	s.status.LastError = ""
+
+	var downloaded, removed int
+	if summary.Gallery != nil {
+		downloaded, removed = len(summary.Gallery.Downloaded), len(summary.Gallery.Removed)
+	}
+	slog.Info("Scheduled sync done", "profileChanged", summary.Profile.Changed, "downloaded", downloaded, "removed", removed)
+}
+
+// Status returns the times of the last scheduled runs.
+func (s *scheduler) Status() syncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -3,32 +3,144 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Scriptum holds every post, drafts and scheduled ones included. Only live
+// posts are listed or served, others can be previewed with previewToken.
 type Scriptum struct {
-	indexTmpl *template.Template
-	pageTmpl  *template.Template
-	Pages     []Page
+	mu           sync.RWMutex
+	indexTmpl    *template.Template
+	Pages        []Page
+	search       *searchIndex
+	loadedAt     time.Time
+	previewToken string
 }
 
 type Page struct {
-	Title string
-	Date  string
-	Desc  string
-	File  string
-	Slug  string
+	Title      string        `json:"title"`
+	Date       string        `json:"date"`
+	Desc       string        `json:"desc"`
+	Tags       []string      `json:"tags,omitempty"`
+	Lang       string        `json:"lang"`
+	Draft      bool          `json:"-"`
+	Updated    string        `json:"updated,omitempty"`
+	Author     string        `json:"author"`
+	Cover      string        `json:"cover,omitempty"` // gallery .rio file name
+	Series     string        `json:"series,omitempty"`
+	Canonical  string        `json:"canonical,omitempty"`
+	Translates string        `json:"translates,omitempty"` // slug of the post this one is a translation of
+	File       string        `json:"-"`
+	Slug       string        `json:"slug"`
+	Content    template.HTML `json:"content,omitempty"` // rendered body, shown in the "post" layout
+
+	// Translations holds the live translations of a post being served.
+	Translations []Page `json:"-"`
+}
+
+const (
+	dateLayout    = "2006-01-02"
+	defaultAuthor = "Lucas Rattz"
+)
+
+// PublishedAt returns the post's date. Dates are validated on load.
+func (p Page) PublishedAt() time.Time {
+	t, _ := time.Parse(dateLayout, p.Date)
+	return t
 }
 
-func newScriptum() (*Scriptum, error) {
+// Live reports whether the post is published at now: it isn't a draft and
+// its date has come.
+func (p Page) Live(now time.Time) bool {
+	return !p.Draft && !p.PublishedAt().After(now)
+}
+
+// UpdatedAt returns when the post was last updated, or its date if it never
+// was.
+func (p Page) UpdatedAt() time.Time {
+	if p.Updated == "" {
+		return p.PublishedAt()
+	}
+	t, _ := time.Parse(dateLayout, p.Updated)
+	return t
+}
+
+// Post sources in scriptum/pages. Go templates define a block named after
+// their slug, executed with the Page as data; the others are rendered by
+// their postRenderer.
+const (
+	goHTMLExt   = ".go.html"
+	jamboExt    = ".jambo"
+	markdownExt = ".md"
+)
+
+// postRenderer renders the body of a post, line being the number of its
+// first line in the file.
+type postRenderer func(body string, line int) (template.HTML, error)
+
+var postRenderers = map[string]postRenderer{
+	jamboExt:    renderJambo,
+	markdownExt: renderMarkdown,
+}
+
+// postSource returns the slug and renderer of a post file name. The renderer
+// is nil for Go templates; ok is false for files that aren't posts.
+func postSource(name string) (slug string, render postRenderer, ok bool) {
+	if slug, ok := strings.CutSuffix(name, goHTMLExt); ok {
+		return slug, nil, true
+	}
+	for ext, render := range postRenderers {
+		if slug, ok := strings.CutSuffix(name, ext); ok {
+			return slug, render, true
+		}
+	}
+	return "", nil, false
+}
+
+func newScriptum(previewToken string) (*Scriptum, error) {
+	s := &Scriptum{previewToken: previewToken}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// reload parses the templates and the page index again, swapping them in
+// only if the templates load. Posts that fail to load are logged and left
+// out.
+func (s *Scriptum) reload() error {
+	postErrs, err := s.load()
+	for _, e := range postErrs {
+		slog.Error(e.Error())
+	}
+	return err
+}
+
+// reloadStrict is reload for dev mode, where the errors of posts that failed
+// to load are returned too, so the dev reloader shows them in the browser
+// instead of the post silently going missing.
+func (s *Scriptum) reloadStrict() error {
+	postErrs, err := s.load()
+	return errors.Join(append([]error{err}, postErrs...)...)
+}
+
+func (s *Scriptum) load() ([]error, error) {
 	indexTmpl, err := template.ParseGlob("scriptum/*.go.html")
 	if err != nil {
 		return nil, errors.New("error parsing scriptum index template: " + err.Error())
@@ -39,79 +151,247 @@ func newScriptum() (*Scriptum, error) {
 		return nil, errors.New("error parsing scriptum page templates: " + err.Error())
 	}
 
-	pages, err := loadScriptumPages()
+	pages, search, postErrs, err := loadScriptumPages(pageTmpl)
 	if err != nil {
 		return nil, errors.New("error loading scriptum pages: " + err.Error())
 	}
 
-	return &Scriptum{
-		Pages:     pages,
-		indexTmpl: indexTmpl,
-		pageTmpl:  pageTmpl,
-	}, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Pages = pages
+	s.search = search
+	s.indexTmpl = indexTmpl
+	s.loadedAt = time.Now()
+
+	return postErrs, nil
 }
 
 func (s *Scriptum) scriptumHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	var buf bytes.Buffer
-	var err error
+	buf, modtime, preview, err := s.render(w, r, id, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("Error rendering Scriptum", "err", err, "id", id)
+		return
+	}
+	if buf == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if preview {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Write(buf.Bytes())
+		return
+	}
+
+	writeHTML(w, r, buf, modtime)
+}
+
+// render renders the index, or the post with the given slug, holding s.mu
+// only while rendering so slow clients don't hold up reloads. buf is nil if
+// there's no such post.
+func (s *Scriptum) render(w http.ResponseWriter, r *http.Request, id string, now time.Time) (buf *bytes.Buffer, modtime time.Time, preview bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	buf = &bytes.Buffer{}
 	if id == "" {
-		err = s.indexTmpl.ExecuteTemplate(&buf, "scriptum", s)
+		err = s.indexTmpl.ExecuteTemplate(buf, "scriptum", s.index(w, r, now))
+	} else if page, ok := s.page(id); ok && (page.Live(now) || s.canPreview(r)) {
+		preview = !page.Live(now)
+		page.Translations = translations(page, s.published(now))
+		w.Header().Set("Content-Language", page.Lang)
+		err = s.indexTmpl.ExecuteTemplate(buf, "post", page)
 	} else {
-		err = s.pageTmpl.ExecuteTemplate(&buf, id, nil)
+		return nil, time.Time{}, false, nil
 	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("Error rendering Scriptum", "err", err, "id", id)
-		return
+	return buf, s.modTime(now), preview, err
+}
+
+type scriptumIndex struct {
+	Pages     []Page
+	Lang      string   // language the pages are filtered by, if any
+	Langs     []string // languages of all live pages
+	Preferred string   // language negotiated from Accept-Language, if any
+}
+
+// index lists the live pages in the language asked for with ?lang=, or every
+// page when there's none. The language negotiated from Accept-Language is
+// only pointed out in the language filter, so no reader is left without the
+// posts in other languages by default.
+func (s *Scriptum) index(w http.ResponseWriter, r *http.Request, now time.Time) scriptumIndex {
+	pages := s.published(now)
+	idx := scriptumIndex{Pages: pages, Langs: pageLangs(pages)}
+
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		w.Header().Add("Vary", "Accept-Language")
+		idx.Preferred = negotiateLang(r.Header.Get("Accept-Language"), idx.Langs)
+	}
+	if lang == "" || lang == "all" {
+		return idx
+	}
+
+	idx.Lang = lang
+	idx.Pages = nil
+	for _, p := range pages {
+		if langMatches(p.Lang, lang) {
+			idx.Pages = append(idx.Pages, p)
+		}
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	return idx
+}
+
+// canPreview reports whether the request carries the preview token, which
+// shows drafts and scheduled posts: /codex/scriptum/{id}?preview=token.
+func (s *Scriptum) canPreview(r *http.Request) bool {
+	token := r.URL.Query().Get("preview")
+	return s.previewToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.previewToken)) == 1
+}
 
-	buf.WriteTo(w)
+// published returns the live pages, newest first. Callers hold s.mu.
+func (s *Scriptum) published(now time.Time) []Page {
+	var pages []Page
+	for _, p := range s.Pages {
+		if p.Live(now) {
+			pages = append(pages, p)
+		}
+	}
+	return pages
 }
 
-func loadScriptumPages() ([]Page, error) {
+// modTime is the last time the published pages changed: when they were
+// loaded, or when the newest scheduled post went live. Callers hold s.mu.
+func (s *Scriptum) modTime(now time.Time) time.Time {
+	modtime := s.loadedAt
+	for _, p := range s.Pages {
+		if p.Live(now) && p.PublishedAt().After(modtime) {
+			modtime = p.PublishedAt()
+		}
+	}
+	return modtime
+}
+
+// page returns the page with the given slug. Callers hold s.mu.
+func (s *Scriptum) page(slug string) (Page, bool) {
+	for _, p := range s.Pages {
+		if p.Slug == slug {
+			return p, true
+		}
+	}
+	return Page{}, false
+}
+
+// LatestPage returns the most recent live page, if there is any.
+func (s *Scriptum) LatestPage() (Page, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.published(time.Now())
+	if len(pages) == 0 {
+		return Page{}, false
+	}
+	return pages[0], true
+}
+
+// Published returns the live pages, newest first.
+func (s *Scriptum) Published() []Page {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.published(time.Now())
+}
+
+// ModTime returns the last time the published pages changed.
+func (s *Scriptum) ModTime() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.modTime(time.Now())
+}
+
+// loadScriptumPages reads the frontmatter of every post, renders its body and
+// indexes it for search. Posts that fail to load are left out, and their
+// errors returned alongside the pages.
+func loadScriptumPages(pageTmpl *template.Template) ([]Page, *searchIndex, []error, error) {
 	pageFiles, err := os.ReadDir("scriptum/pages")
 	if err != nil {
-		return []Page{}, errors.New("error listing page files: " + err.Error())
+		return []Page{}, nil, nil, errors.New("error listing page files: " + err.Error())
 	}
 
 	var pages []Page
+	var errs []error
+	slugs := map[string]string{}
 	for _, f := range pageFiles {
 		name := f.Name()
 
+		slug, render, ok := postSource(name)
+		if !ok {
+			continue
+		}
+
+		if other, ok := slugs[slug]; ok {
+			errs = append(errs, errors.New(name+" has the same slug as "+other+", skipping it"))
+			continue
+		}
+
 		fm, err := readFrontmatter("scriptum/pages/" + name)
 		if err != nil {
-			slog.Error("error reading frontmatter of " + name + ": " + err.Error())
+			errs = append(errs, errors.New("error reading frontmatter of "+name+": "+err.Error()))
 			continue
 		}
 
-		err = validateFrontmatter(fm)
+		page, warnings, err := parseFrontmatter(fm)
 		if err != nil {
-			slog.Error(name + " has invalid frontmatter: " + err.Error())
+			errs = append(errs, errors.New(name+" has invalid frontmatter: "+err.Error()))
 			continue
 		}
+		for _, w := range warnings {
+			slog.Warn(name + ": " + w)
+		}
+		page.File, page.Slug = name, slug
+
+		if render == nil {
+			var buf bytes.Buffer
+			if err := pageTmpl.ExecuteTemplate(&buf, slug, page); err != nil {
+				errs = append(errs, errors.New("error rendering "+name+": "+err.Error()))
+				continue
+			}
+			page.Content = template.HTML(strings.TrimSpace(buf.String()))
+		} else {
+			body, line, err := readBody("scriptum/pages/" + name)
+			if err != nil {
+				errs = append(errs, errors.New("error reading "+name+": "+err.Error()))
+				continue
+			}
+
+			page.Content, err = render(body, line)
+			if err != nil {
+				errs = append(errs, errors.New("error rendering "+name+": "+err.Error()))
+				continue
+			}
+		}
 
-		slug := strings.Split(name, ".go.html")[0]
-
-		page := Page{Title: fm["title"], Date: fm["date"], Desc: fm["desc"], File: name, Slug: slug}
+		slugs[slug] = name
 		pages = append(pages, page)
 	}
 
-	sort.Slice(pages, func(i, j int) bool {
-		t1, err1 := time.Parse("2006-01-02", pages[i].Date)
-		t2, err2 := time.Parse("2006-01-02", pages[j].Date)
-		if err1 != nil || err2 != nil {
-			return false
+	for _, p := range pages {
+		if p.Translates != "" && slugs[p.Translates] == "" {
+			slog.Warn(p.File + " translates " + p.Translates + ", which doesn't exist")
 		}
-		return t1.After(t2)
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].PublishedAt().After(pages[j].PublishedAt())
 	})
 
-	return pages, nil
+	return pages, newSearchIndex(pages), errs, nil
 }
 
 func readFrontmatter(path string) (map[string]string, error) {
@@ -132,6 +412,10 @@ func readFrontmatter(path string) (map[string]string, error) {
 		return nil, errors.New("bad formatting: frontmatter must be at the top")
 	}
 
+	// listKey is the key of an empty value, which may be followed by a
+	// block list of "- item" lines.
+	var listKey string
+
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "---" {
@@ -142,6 +426,14 @@ func readFrontmatter(path string) (map[string]string, error) {
 			continue
 		}
 
+		if item, ok := strings.CutPrefix(line, "- "); ok && listKey != "" {
+			if frontmatter[listKey] != "" {
+				frontmatter[listKey] += ", "
+			}
+			frontmatter[listKey] += strings.TrimSpace(item)
+			continue
+		}
+
 		var key, value string
 		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
 			key = strings.TrimSpace(parts[0])
@@ -154,6 +446,10 @@ func readFrontmatter(path string) (map[string]string, error) {
 		}
 
 		frontmatter[key] = value
+		listKey = ""
+		if value == "" {
+			listKey = key
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -163,24 +459,153 @@ func readFrontmatter(path string) (map[string]string, error) {
 	return frontmatter, nil
 }
 
-func validateFrontmatter(fm map[string]string) error {
-	if len(fm) != 3 {
-		return fmt.Errorf("frontmatter must contain exactly 'title', 'date' and 'desc'")
+// readBody returns what follows the frontmatter of a post, along with the
+// number of its first line.
+func readBody(path string) (string, int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return "", 0, errors.New("bad formatting: frontmatter must be at the top")
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			return strings.Join(lines[i+1:], ""), i + 2, nil
+		}
+	}
+
+	return "", 0, errors.New("bad formatting: frontmatter is not closed")
+}
+
+// parseFrontmatter builds a Page from frontmatter. title, date and desc are
+// required; tags, lang, draft, updated, author, cover, series, canonical and
+// translates are optional. Malformed values are errors, unknown keys only
+// warnings.
+func parseFrontmatter(fm map[string]string) (Page, []string, error) {
+	var page Page
+	var warnings []string
+
+	for _, key := range slices.Sorted(maps.Keys(fm)) {
+		value := strings.TrimSpace(fm[key])
+
+		switch key {
+		case "title":
+			page.Title = value
+		case "date":
+			page.Date = value
+		case "desc":
+			page.Desc = value
+		case "tags":
+			for _, tag := range parseList(value) {
+				tag = normalizeTag(tag)
+				if !slices.Contains(page.Tags, tag) {
+					page.Tags = append(page.Tags, tag)
+				}
+			}
+		case "lang":
+			if !validLang(value) {
+				return Page{}, nil, fmt.Errorf("'lang' must be a language tag such as en or pt-BR, got %q", value)
+			}
+			page.Lang = value
+		case "draft":
+			draft, err := strconv.ParseBool(value)
+			if err != nil {
+				return Page{}, nil, fmt.Errorf("'draft' must be true or false, got %q", value)
+			}
+			page.Draft = draft
+		case "updated":
+			page.Updated = value
+		case "author":
+			page.Author = value
+		case "cover":
+			if filepath.Base(value) != value || !strings.HasSuffix(value, ".rio") {
+				return Page{}, nil, fmt.Errorf("'cover' must be the file name of a gallery .rio image, got %q", value)
+			}
+			page.Cover = value
+		case "series":
+			page.Series = value
+		case "translates":
+			page.Translates = value
+		case "canonical":
+			if u, err := url.Parse(value); err != nil || !u.IsAbs() || u.Host == "" {
+				return Page{}, nil, fmt.Errorf("'canonical' must be an absolute URL, got %q", value)
+			}
+			page.Canonical = value
+		default:
+			warnings = append(warnings, "unknown frontmatter key '"+key+"', ignoring it")
+		}
+	}
+
+	if page.Title == "" {
+		return Page{}, nil, fmt.Errorf("missing or empty 'title'")
+	}
+	if page.Date == "" {
+		return Page{}, nil, fmt.Errorf("missing or empty 'date'")
+	}
+	if page.Desc == "" {
+		return Page{}, nil, fmt.Errorf("missing or empty 'desc'")
 	}
 
-	title, okTitle := fm["title"]
-	date, okDate := fm["date"]
-	desc, okDesc := fm["desc"]
+	if _, err := time.Parse(dateLayout, page.Date); err != nil {
+		return Page{}, nil, fmt.Errorf("'date' must be YYYY-MM-DD, got %q", page.Date)
+	}
+	if page.Updated != "" {
+		if _, err := time.Parse(dateLayout, page.Updated); err != nil {
+			return Page{}, nil, fmt.Errorf("'updated' must be YYYY-MM-DD, got %q", page.Updated)
+		}
+		if page.Updated < page.Date {
+			warnings = append(warnings, "'updated' is before 'date'")
+		}
+	}
 
-	if !okTitle || strings.TrimSpace(title) == "" {
-		return fmt.Errorf("missing or empty 'title'")
+	if page.Author == "" {
+		page.Author = defaultAuthor
 	}
-	if !okDate || strings.TrimSpace(date) == "" {
-		return fmt.Errorf("missing or empty 'date'")
+	if page.Lang == "" {
+		page.Lang = defaultLang
+	}
+
+	return page, warnings, nil
+}
+
+// parseList parses "a, b", "[a, b]" or a block list joined by readFrontmatter.
+func parseList(value string) []string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
 	}
-	if !okDesc || strings.TrimSpace(desc) == "" {
-		return fmt.Errorf("missing or empty 'desc'")
+
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"'`)
+		if item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	return nil
+// validLang loosely checks a BCP 47 tag: a 2 or 3 letter language followed
+// by alphanumeric subtags.
+func validLang(tag string) bool {
+	parts := strings.Split(tag, "-")
+	if l := len(parts[0]); l < 2 || l > 3 {
+		return false
+	}
+	for i, part := range parts {
+		if part == "" || len(part) > 8 {
+			return false
+		}
+		for _, c := range part {
+			isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+			if !isLetter && (i == 0 || c < '0' || c > '9') {
+				return false
+			}
+		}
+	}
+	return true
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScriptumReloadStrict(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{
+		"scriptum/index.go.html":    `{{ define "scriptum" }}{{ end }}`,
+		"scriptum/pages/ok.go.html": "---\ntitle: OK\ndate: 2025-01-01\ndesc: Fine.\n---\n{{ define \"ok\" }}<p>ok</p>{{ end }}\n",
+		"scriptum/pages/md.md":      "---\ntitle: MD\ndate: 2025-01-02\ndesc: Fine.\n---\nSome *text*.\n",
+		"scriptum/pages/nodate.md":  "---\ntitle: No date\ndesc: Broken.\n---\nText.\n",
+		"scriptum/pages/bad.jambo":  "---\ntitle: Bad\ndate: 2025-01-03\ndesc: Broken.\n---\n[unclosed|\n",
+	})
+
+	s, err := newScriptum("")
+	if err != nil {
+		t.Fatalf("newScriptum failed on broken posts: %v", err)
+	}
+	if len(s.Pages) != 2 {
+		t.Errorf("loaded %d pages, want the 2 valid ones", len(s.Pages))
+	}
+
+	err = s.reloadStrict()
+	if err == nil {
+		t.Fatal("reloadStrict didn't report the broken posts")
+	}
+	for _, name := range []string{"nodate.md", "bad.jambo"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error doesn't mention %s: %v", name, err)
+		}
+	}
+	if len(s.Pages) != 2 {
+		t.Errorf("reloadStrict kept %d pages, want 2", len(s.Pages))
+	}
+}
+
+func TestScriptumIndexLang(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{
+		"scriptum/index.go.html":    `{{ define "scriptum" }}{{ end }}`,
+		"scriptum/pages/en.go.html": "---\ntitle: EN\ndate: 2025-01-01\ndesc: English.\nlang: en\n---\n{{ define \"en\" }}<p>Text.</p>{{ end }}\n",
+		"scriptum/pages/pt.md":      "---\ntitle: PT\ndate: 2025-01-02\ndesc: Portuguese.\nlang: pt-BR\n---\nTexto.\n",
+	})
+
+	s, err := newScriptum("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, query, accept string
+		lang, preferred     string
+		pages               int
+	}{
+		{"no preference", "", "", "", "", 2},
+		{"negotiated", "", "pt-BR,pt;q=0.9", "", "pt-BR", 2},
+		{"filtered", "?lang=en", "pt-BR", "en", "", 1},
+		{"all", "?lang=all", "pt-BR", "", "", 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/codex/scriptum"+tt.query, nil)
+			r.Header.Set("Accept-Language", tt.accept)
+			w := httptest.NewRecorder()
+
+			s.mu.RLock()
+			idx := s.index(w, r, time.Now())
+			s.mu.RUnlock()
+
+			if idx.Lang != tt.lang || idx.Preferred != tt.preferred || len(idx.Pages) != tt.pages {
+				t.Errorf("got lang %q, preferred %q, %d pages; want %q, %q, %d",
+					idx.Lang, idx.Preferred, len(idx.Pages), tt.lang, tt.preferred, tt.pages)
+			}
+		})
+	}
+}
+
+// blockedWriter stands in for a slow client: Write blocks until release is
+// closed.
+type blockedWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w blockedWriter) Write(b []byte) (int, error) {
+	close(w.writing)
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
+
+// TestScriptumHandlerUnlocksBeforeWriting checks that a reload can take the
+// lock while a page is still being written to a slow client.
+func TestScriptumHandlerUnlocksBeforeWriting(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{
+		"scriptum/index.go.html":    `{{ define "scriptum" }}index{{ end }}{{ define "tags" }}tags{{ end }}{{ define "search" }}search{{ end }}`,
+		"scriptum/pages/ok.go.html": "---\ntitle: OK\ndate: 2025-01-01\ndesc: Fine.\n---\n{{ define \"ok\" }}<p>ok</p>{{ end }}\n",
+	})
+
+	s, err := newScriptum("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/codex/scriptum", s.scriptumHandler},
+		{"/codex/scriptum/tags", s.tagsHandler},
+		{"/codex/scriptum/search?q=ok", s.searchHandler},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			w := blockedWriter{httptest.NewRecorder(), make(chan struct{}), make(chan struct{})}
+			done := make(chan struct{})
+			go func() {
+				tt.handler(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+				close(done)
+			}()
+			defer func() { <-done }()
+			defer close(w.release)
+
+			<-w.writing
+			locked := make(chan struct{})
+			go func() {
+				s.mu.Lock()
+				s.mu.Unlock()
+				close(locked)
+			}()
+
+			select {
+			case <-locked:
+			case <-time.After(time.Second):
+				t.Error("handler held the lock while writing the response")
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	searchMaxResults  = 50
+	searchMaxQuery    = 200
+	snippetContext    = 80  // runes shown before the first match
+	snippetLength     = 240 // runes in a snippet
+	titleWeight       = 5
+	descWeight        = 2
+	bodyWeight        = 1
+	minSearchTermSize = 2
+)
+
+// searchIndex is an inverted index over the posts' titles, descriptions and
+// rendered text. Terms are lowercased, stripped of accents and lightly
+// stemmed, so "Crônicas" finds "cronica".
+type searchIndex struct {
+	docs  []searchDoc
+	terms map[string][]posting
+}
+
+type searchDoc struct {
+	slug string
+	desc string
+	text []rune // plain text of the body, for snippets
+}
+
+type posting struct {
+	doc    int
+	weight float64
+}
+
+type searchResult struct {
+	Page    Page
+	Snippet template.HTML
+}
+
+var foldTable = map[rune]rune{}
+
+func init() {
+	for base, accented := range map[rune]string{
+		'a': "áàâãäå", 'e': "éèêë", 'i': "íìîï", 'o': "óòôõö",
+		'u': "úùûü", 'c': "ç", 'n': "ñ", 'y': "ýÿ",
+	} {
+		for _, r := range accented {
+			foldTable[r] = base
+		}
+	}
+}
+
+// fold lowercases r and strips its accent. It maps rune to rune, so folded
+// text lines up with the original.
+func fold(r rune) rune {
+	r = unicode.ToLower(r)
+	if base, ok := foldTable[r]; ok {
+		return base
+	}
+	return r
+}
+
+var stopWords = map[string]bool{
+	"a": true, "o": true, "e": true, "as": true, "os": true, "de": true, "da": true, "do": true,
+	"das": true, "dos": true, "em": true, "no": true, "na": true, "um": true, "uma": true,
+	"que": true, "se": true, "por": true, "para": true, "com": true,
+	"the": true, "of": true, "and": true, "to": true, "in": true, "is": true, "it": true,
+	"an": true, "for": true, "on": true, "with": true,
+}
+
+// stemSuffixes are tried in order; the first that leaves at least three
+// letters is replaced. It's meant to merge plurals and common endings of
+// Portuguese and English words, not to be a real stemmer.
+var stemSuffixes = []struct{ suffix, repl string }{
+	{"coes", "cao"}, {"oes", "ao"}, {"aes", "ao"}, {"ais", "al"}, {"eis", "el"},
+	{"mente", ""}, {"ies", "y"}, {"ing", ""}, {"ed", ""}, {"ly", ""}, {"ns", "m"},
+}
+
+func stem(word string) string {
+	for _, s := range stemSuffixes {
+		if base, ok := strings.CutSuffix(word, s.suffix); ok && len(base) >= 3 {
+			return base + s.repl
+		}
+	}
+	if base, ok := strings.CutSuffix(word, "s"); ok && len(base) >= 3 && !strings.HasSuffix(base, "s") {
+		return base
+	}
+	return word
+}
+
+type token struct {
+	start, end int // rune offsets in the text
+	term       string
+}
+
+// tokenize splits text into stemmed terms, skipping stop words and terms
+// too short to be useful.
+func tokenize(text []rune) []token {
+	var tokens []token
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		isWord := i < len(text) && (unicode.IsLetter(text[i]) || unicode.IsDigit(text[i]))
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			word := make([]rune, i-start)
+			for j, r := range text[start:i] {
+				word[j] = fold(r)
+			}
+			if w := string(word); len(word) >= minSearchTermSize && !stopWords[w] {
+				tokens = append(tokens, token{start: start, end: i, term: stem(w)})
+			}
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// plainText strips the tags of rendered HTML.
+func plainText(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+			b.WriteRune(' ')
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(b.String())), " ")
+}
+
+func newSearchIndex(pages []Page) *searchIndex {
+	idx := &searchIndex{terms: map[string][]posting{}}
+
+	for i, p := range pages {
+		text := []rune(plainText(string(p.Content)))
+		idx.docs = append(idx.docs, searchDoc{slug: p.Slug, desc: p.Desc, text: text})
+
+		tf := map[string]float64{}
+		for _, field := range []struct {
+			text   []rune
+			weight float64
+		}{
+			{[]rune(p.Title), titleWeight},
+			{[]rune(p.Desc), descWeight},
+			{text, bodyWeight},
+		} {
+			for _, t := range tokenize(field.text) {
+				tf[t.term] += field.weight
+			}
+		}
+
+		for term, n := range tf {
+			idx.terms[term] = append(idx.terms[term], posting{doc: i, weight: 1 + math.Log(n)})
+		}
+	}
+
+	return idx
+}
+
+// search returns the slugs of the documents containing every term of query,
+// best first, and a highlighted snippet for each.
+func (idx *searchIndex) search(query string) ([]string, map[string]template.HTML) {
+	var terms []string
+	for _, t := range tokenize([]rune(query)) {
+		if !slices.Contains(terms, t.term) {
+			terms = append(terms, t.term)
+		}
+	}
+	if len(terms) == 0 || len(idx.docs) == 0 {
+		return nil, nil
+	}
+
+	scores := map[int]float64{}
+	matched := map[int]int{}
+	for _, term := range terms {
+		postings := idx.terms[term]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(max(len(postings), 1)))
+		for _, p := range postings {
+			scores[p.doc] += p.weight * idf
+			matched[p.doc]++
+		}
+	}
+
+	var docs []int
+	for doc, n := range matched {
+		if n == len(terms) {
+			docs = append(docs, doc)
+		}
+	}
+	slices.SortFunc(docs, func(a, b int) int {
+		switch {
+		case scores[a] > scores[b]:
+			return -1
+		case scores[a] < scores[b]:
+			return 1
+		}
+		return a - b
+	})
+
+	slugs := make([]string, len(docs))
+	snippets := make(map[string]template.HTML, len(docs))
+	for i, doc := range docs {
+		d := idx.docs[doc]
+		slugs[i] = d.slug
+		snippets[d.slug] = snippet(d, terms)
+	}
+
+	return slugs, snippets
+}
+
+// snippet returns the text around the first match in the body with every
+// match marked, or the description if only the title or description matched.
+func snippet(d searchDoc, terms []string) template.HTML {
+	tokens := tokenize(d.text)
+
+	first := slices.IndexFunc(tokens, func(t token) bool { return slices.Contains(terms, t.term) })
+	if first < 0 {
+		return template.HTML(html.EscapeString(d.desc))
+	}
+
+	start := max(0, tokens[first].start-snippetContext)
+	for start > 0 && start < tokens[first].start && !unicode.IsSpace(d.text[start-1]) {
+		start++
+	}
+	end := min(len(d.text), start+snippetLength)
+	for end < len(d.text) && end > tokens[first].end && !unicode.IsSpace(d.text[end]) {
+		end--
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	pos := start
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !slices.Contains(terms, t.term) {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(d.text[pos:t.start])))
+		b.WriteString("<mark>" + html.EscapeString(string(d.text[t.start:t.end])) + "</mark>")
+		pos = t.end
+	}
+	b.WriteString(html.EscapeString(string(d.text[pos:end])))
+	if end < len(d.text) {
+		b.WriteString(" …")
+	}
+
+	return template.HTML(b.String())
+}
+
+// searchHandler serves /codex/scriptum/search?q=, a plain GET form.
+func (s *Scriptum) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(query)) > searchMaxQuery {
+		query = string([]rune(query)[:searchMaxQuery])
+	}
+
+	buf, modtime, err := s.renderSearch(query, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("Error rendering Scriptum search", "err", err, "q", query)
+		return
+	}
+
+	writeHTML(w, r, buf, modtime)
+}
+
+// renderSearch renders the results for query, holding s.mu only while
+// rendering.
+func (s *Scriptum) renderSearch(query string, now time.Time) (*bytes.Buffer, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := struct {
+		Query   string
+		Results []searchResult
+	}{Query: query}
+
+	if query != "" && s.search != nil {
+		slugs, snippets := s.search.search(query)
+		for _, slug := range slugs {
+			if p, ok := s.page(slug); ok && p.Live(now) {
+				data.Results = append(data.Results, searchResult{Page: p, Snippet: snippets[slug]})
+			}
+			if len(data.Results) == searchMaxResults {
+				break
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := s.indexTmpl.ExecuteTemplate(&buf, "search", data)
+	return &buf, s.modTime(now), err
+}
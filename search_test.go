@@ -0,0 +1,112 @@
+package main
+
+import (
+	"html/template"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	for in, want := range map[rune]rune{'Á': 'a', 'ç': 'c', 'Õ': 'o', 'Z': 'z', 'ß': 'ß', '1': '1'} {
+		if got := fold(in); got != want {
+			t.Errorf("fold(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStem(t *testing.T) {
+	for in, want := range map[string]string{
+		"cronicas": "cronica",
+		"nacoes":   "nacao",
+		"animais":  "animal",
+		"papeis":   "papel",
+		"homens":   "homem",
+		"quickly":  "quick",
+		"stories":  "story",
+		"classes":  "classe",
+		"glass":    "glass",
+		"bus":      "bus",
+	} {
+		if got := stem(in); got != want {
+			t.Errorf("stem(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize([]rune("O Café, e as crônicas!"))
+	want := []token{{2, 6, "cafe"}, {13, 21, "cronica"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("tokenize = %v, want %v", got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	doc := func(text string) searchDoc {
+		return searchDoc{desc: "Soup & more.", text: []rune(text)}
+	}
+
+	for _, tt := range []struct {
+		name string
+		text string
+		want template.HTML
+	}{
+		{"every match marked", "Sopa <de> cogumelos & Cogumelos.", "Sopa &lt;de&gt; <mark>cogumelos</mark> &amp; <mark>Cogumelos</mark>."},
+		{"no match in body", "Nothing here.", "Soup &amp; more."},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(doc(tt.text), []string{"cogumelo"}); got != tt.want {
+				t.Errorf("snippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long := strings.Repeat("palavra ", 30) + "alvo " + strings.Repeat("palavra ", 50)
+	got := string(snippet(doc(long), []string{"alvo"}))
+	if !strings.HasPrefix(got, "… palavra ") || !strings.HasSuffix(got, " …") {
+		t.Errorf("long snippet isn't cut at words with ellipses: %q", got)
+	}
+	if !strings.Contains(got, "<mark>alvo</mark>") {
+		t.Errorf("long snippet lost the match: %q", got)
+	}
+	text := strings.NewReplacer("<mark>", "", "</mark>", "", "… ", "", " …", "").Replace(got)
+	if n := len([]rune(text)); n > snippetLength {
+		t.Errorf("long snippet has %d runes of text, want at most %d", n, snippetLength)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := newSearchIndex([]Page{
+		{Slug: "body", Title: "Outro", Desc: "Um post.", Content: "<p>Falei de cogumelos.</p>"},
+		{Slug: "title", Title: "Sopa de cogumelos", Desc: "Receita.", Content: "<p>Receita.</p>"},
+		{Slug: "none", Title: "Nada", Desc: "Nada.", Content: "<p>Nada.</p>"},
+	})
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"Cogumelo", []string{"title", "body"}},
+		{"cogumelos SOPA", []string{"title"}},
+		{"cogumelos xadrez", nil},
+		{"de", nil},
+		{"", nil},
+	} {
+		slugs, snippets := idx.search(tt.query)
+		if !slices.Equal(slugs, tt.want) {
+			t.Errorf("search(%q) = %v, want %v", tt.query, slugs, tt.want)
+		}
+		if len(snippets) != len(slugs) {
+			t.Errorf("search(%q) returned %d snippets for %d results", tt.query, len(snippets), len(slugs))
+		}
+	}
+
+	_, snippets := idx.search("cogumelos")
+	if want := template.HTML("Falei de <mark>cogumelos</mark>."); snippets["body"] != want {
+		t.Errorf("body snippet = %q, want %q", snippets["body"], want)
+	}
+	if want := template.HTML("Receita."); snippets["title"] != want {
+		t.Errorf("title snippet = %q, want %q", snippets["title"], want)
+	}
+}
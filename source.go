@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GallerySource lists and opens the .rio files the gallery is synced from.
+// SHAs only need to change whenever a file's contents do; all built-in
+// sources use git blob SHAs so switching between them doesn't redownload
+// unchanged files.
+type GallerySource interface {
+	List() ([]galleryFile, error)
+	Open(f galleryFile) (io.ReadCloser, error)
+}
+
+type galleryFile struct {
+	Name string `json:"name"`
+	SHA  string `json:"sha"`
+	URL  string `json:"url"`
+}
+
+// newGallerySource picks a source from GALLERY_SOURCE: empty or "github" for
+// the repository's gallery directory on GitHub, an http(s) URL for a
+// manifest, anything else for a local directory. The GitHub token is
+// optional.
+func newGallerySource(spec, githubToken string) GallerySource {
+	switch {
+	case spec == "" || spec == "github":
+		return newGithubSource(galleryTreeURL, galleryRawURL, githubToken)
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return manifestSource{url: spec}
+	default:
+		return dirSource{dir: spec}
+	}
+}
+
+// githubTree is a response of the Git Trees API. Unlike the contents API,
+// which stops at 1000 entries without saying so, it reports when a listing
+// didn't fit in a response.
+type githubTree struct {
+	Tree []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+	} `json:"tree"`
+	Truncated bool `json:"truncated"`
+}
+
+const (
+	githubMaxRetries = 3
+	githubMaxWait    = 30 * time.Second // longer rate limit waits fail instead
+)
+
+// githubSource lists files through the GitHub Git Trees API and downloads
+// them from raw.githubusercontent.com. The listing is fetched with
+// If-None-Match, so an unchanged directory costs nothing against the rate
+// limit, and requests are authenticated when a token is set.
+type githubSource struct {
+	treeURL string
+	rawURL  string // prefix of the download URLs, the file name is appended
+	token   string
+
+	mu    sync.Mutex
+	etag  string
+	files []galleryFile
+}
+
+type rateLimitError struct {
+	reset time.Time
+}
+
+func (e rateLimitError) Error() string {
+	return "GitHub API rate limit exceeded, resets at " + e.reset.Format(time.RFC3339)
+}
+
+func newGithubSource(treeURL, rawURL, token string) *githubSource {
+	return &githubSource{treeURL: treeURL, rawURL: rawURL, token: token}
+}
+
+func (s *githubSource) List() ([]galleryFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.treeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && s.etag != "" {
+		return s.files, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var tree githubTree
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("GitHub truncated the listing of %s at %d entries", s.treeURL, len(tree.Tree))
+	}
+
+	var files []galleryFile
+	for _, e := range tree.Tree {
+		if e.Type != "blob" {
+			continue
+		}
+		files = append(files, galleryFile{Name: e.Path, SHA: e.SHA, URL: s.rawURL + url.PathEscape(e.Path)})
+	}
+
+	s.etag, s.files = resp.Header.Get("ETag"), files
+	return files, nil
+}
+
+func (s *githubSource) Open(f galleryFile) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned status %d", f.URL, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// do sends a request to GitHub, retrying server errors with exponential
+// backoff and waiting out rate limits when they reset soon enough.
+func (s *githubSource) do(req *http.Request) (*http.Response, error) {
+	if s.token != "" && isGithubHost(req.URL.Host) {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if attempt >= githubMaxRetries {
+				return nil, err
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+
+		remaining := resp.Header.Get("X-RateLimit-Remaining")
+		if remaining != "" && remaining != "0" {
+			if n, err := strconv.Atoi(remaining); err == nil && n < 10 {
+				slog.Warn("GitHub API rate limit running low", "remaining", n)
+			}
+		}
+
+		wait, limited := rateLimitWait(resp)
+		switch {
+		case limited:
+			resp.Body.Close()
+			if wait > githubMaxWait || attempt >= githubMaxRetries {
+				return nil, rateLimitError{reset: time.Now().Add(wait)}
+			}
+			slog.Warn("GitHub API rate limited, waiting", "wait", wait)
+			time.Sleep(wait)
+		case resp.StatusCode >= 500 && attempt < githubMaxRetries:
+			resp.Body.Close()
+			time.Sleep(backoff)
+			backoff *= 2
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// rateLimitWait reports whether resp is a rate limit rejection and how long
+// to wait before retrying, from Retry-After or X-RateLimit-Reset.
+func rateLimitWait(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Minute, resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Minute, true
+	}
+
+	return max(time.Until(time.Unix(reset, 0)), 0), true
+}
+
+func isGithubHost(host string) bool {
+	return host == "api.github.com" || host == "raw.githubusercontent.com"
+}
+
+// manifestSource reads a JSON array of {"name", "sha", "url"} objects, where
+// sha is the git blob SHA of the file. URLs may be relative to the manifest
+// itself.
+type manifestSource struct {
+	url string
+}
+
+func (s manifestSource) List() ([]galleryFile, error) {
+	body, err := httpOpen(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var files []galleryFile
+	if err := json.NewDecoder(body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("invalid gallery manifest: %w", err)
+	}
+
+	base, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range files {
+		if f.Name == "" || f.SHA == "" {
+			return nil, fmt.Errorf("invalid gallery manifest: entry %d needs a name and a sha", i)
+		}
+
+		ref := f.URL
+		if ref == "" {
+			ref = url.PathEscape(f.Name)
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gallery manifest: entry %d: %w", i, err)
+		}
+		files[i].URL = u.String()
+	}
+
+	return files, nil
+}
+
+func (s manifestSource) Open(f galleryFile) (io.ReadCloser, error) {
+	return httpOpen(f.URL)
+}
+
+type dirSource struct {
+	dir string
+}
+
+func (s dirSource) List() ([]galleryFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []galleryFile
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		sha, err := gitBlobSHA(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, galleryFile{Name: e.Name(), SHA: sha})
+	}
+
+	return files, nil
+}
+
+func (s dirSource) Open(f galleryFile) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.Base(f.Name)))
+}
+
+// gitBlobSHA hashes a file the way git does, matching GitHub's content SHAs.
+func gitBlobSHA(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	io.WriteString(h, "blob "+strconv.FormatInt(stat.Size(), 10)+"\x00")
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func httpOpen(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGithubSourceList(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"sha":"t","tree":[
+			{"path":"A b.rio","type":"blob","sha":"s1"},
+			{"path":"sub","type":"tree","sha":"s2"},
+			{"path":"C.rio","type":"blob","sha":"s3"}
+		],"truncated":false}`))
+	}))
+	defer srv.Close()
+
+	s := newGithubSource(srv.URL+"/git/trees/main:gallery/content", "https://raw.example/gallery/", "")
+	want := []galleryFile{
+		{Name: "A b.rio", SHA: "s1", URL: "https://raw.example/gallery/A%20b.rio"},
+		{Name: "C.rio", SHA: "s3", URL: "https://raw.example/gallery/C.rio"},
+	}
+
+	for i := range 2 {
+		files, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(files, want) {
+			t.Errorf("listing %d = %v, want %v", i+1, files, want)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want 2", requests)
+	}
+}
+
+func TestGithubSourceListTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tree":[{"path":"A.rio","type":"blob","sha":"s1"}],"truncated":true}`))
+	}))
+	defer srv.Close()
+
+	files, err := newGithubSource(srv.URL, "", "").List()
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("List() = %v, %v; want a truncation error", files, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type tagCount struct {
+	Tag   string
+	Count int
+}
+
+// normalizeTag lowercases tag and joins its words with dashes, so "Open
+// Source" and "open source" are the same tag, "open-source".
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// tagCounts counts the tags of pages, most used first.
+func tagCounts(pages []Page) []tagCount {
+	counts := map[string]int{}
+	for _, p := range pages {
+		for _, tag := range p.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]tagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, tagCount{Tag: tag, Count: n})
+	}
+	slices.SortFunc(tags, func(a, b tagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+
+	return tags
+}
+
+// tagsHandler serves /codex/scriptum/tags, listing every tag of the live
+// posts, and /codex/scriptum/tags/{tag}, listing the posts with that tag.
+func (s *Scriptum) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	raw := r.PathValue("tag")
+	tag := normalizeTag(raw)
+	if raw != "" && tag == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	buf, modtime, err := s.renderTags(tag, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("Error rendering Scriptum tags", "err", err, "tag", tag)
+		return
+	}
+	if buf == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeHTML(w, r, buf, modtime)
+}
+
+// renderTags renders the tag list, or the posts with tag, holding s.mu only
+// while rendering. buf is nil if no live post has the tag.
+func (s *Scriptum) renderTags(tag string, now time.Time) (*bytes.Buffer, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := s.published(now)
+
+	var buf bytes.Buffer
+	var err error
+
+	if tag == "" {
+		err = s.indexTmpl.ExecuteTemplate(&buf, "tags", tagCounts(pages))
+	} else {
+		var tagged []Page
+		for _, p := range pages {
+			if slices.Contains(p.Tags, tag) {
+				tagged = append(tagged, p)
+			}
+		}
+		if len(tagged) == 0 {
+			return nil, time.Time{}, nil
+		}
+
+		data := struct {
+			Tag   string
+			Pages []Page
+		}{Tag: tag, Pages: tagged}
+		err = s.indexTmpl.ExecuteTemplate(&buf, "tag", data)
+	}
+
+	return &buf, s.modTime(now), err
+}
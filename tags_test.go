@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	for in, want := range map[string]string{
+		"poem":          "poem",
+		"Chronicle":     "chronicle",
+		"Open  Source ": "open-source",
+		"open-source":   "open-source",
+		" ":             "",
+	} {
+		if got := normalizeTag(in); got != want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTagsHandler(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFiles(t, map[string]string{
+		"scriptum/index.go.html":   `{{ define "tags" }}tags{{ end }}{{ define "tag" }}{{ .Tag }}:{{ range .Pages }} {{ .Slug }}{{ end }}{{ end }}`,
+		"scriptum/pages/a.go.html": "---\ntitle: A\ndate: 2025-01-01\ndesc: A.\ntags: [Chronicle, Open Source]\n---\n{{ define \"a\" }}<p>a</p>{{ end }}\n",
+	})
+
+	s, err := newScriptum("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/codex/scriptum/tags/{tag}", s.tagsHandler)
+
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/codex/scriptum/tags/chronicle", http.StatusOK, "chronicle: a"},
+		{"/codex/scriptum/tags/Chronicle", http.StatusOK, "chronicle: a"},
+		{"/codex/scriptum/tags/open-source", http.StatusOK, "open-source: a"},
+		{"/codex/scriptum/tags/open%20source", http.StatusOK, "open-source: a"},
+		{"/codex/scriptum/tags/Open%20Source", http.StatusOK, "open-source: a"},
+		{"/codex/scriptum/tags/poem", http.StatusNotFound, ""},
+		{"/codex/scriptum/tags/%20", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.code)
+		}
+		if tt.body != "" && !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("GET %s: body %q, want %q", tt.path, w.Body, tt.body)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWebPThumbnail serves a v1 .rio file from gallery/content, which has no
+// thumb rendition and holds a WebP image, and checks that ?size=thumb returns
+// a generated JPEG instead of the full image.
+func TestWebPThumbnail(t *testing.T) {
+	g := newTestGallery(t, "Gecko.rio")
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := serveImage(g, "Gecko.rio", query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", query, w.Code)
+		}
+		return w
+	}
+
+	full := get("")
+	if ct := full.Header().Get("Content-Type"); ct != "image/webp" {
+		t.Fatalf("full Content-Type = %q, want image/webp", ct)
+	}
+
+	thumb := get("?size=thumb")
+	if ct := thumb.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("thumb Content-Type = %q, want image/jpeg", ct)
+	}
+	if thumb.Body.Len() >= full.Body.Len() {
+		t.Errorf("thumb is %d bytes, full image is %d", thumb.Body.Len(), full.Body.Len())
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(thumb.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width > thumbWidth {
+		t.Errorf("thumb is %dpx wide, want at most %d", cfg.Width, thumbWidth)
+	}
+}
+
+// TestGeneratedThumbnailNoBudget checks that generated thumbnails are kept
+// even when the image cache has no room for them.
+func TestGeneratedThumbnailNoBudget(t *testing.T) {
+	g := newTestGallery(t, "Gecko.rio")
+	g.images = newImageCache(0)
+
+	for range 2 {
+		if w := serveImage(g, "Gecko.rio", "?size=thumb"); w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+	}
+	if n := g.CacheStats().Generated; n != 1 {
+		t.Errorf("%d generated thumbnails kept, want 1", n)
+	}
+
+	g.invalidate("Gecko.rio")
+	if n := g.CacheStats().Generated; n != 0 {
+		t.Errorf("%d generated thumbnails kept after invalidating, want 0", n)
+	}
+}
+
+func TestThumbStoreGeneratesOnce(t *testing.T) {
+	s := newThumbStore()
+	key := imageKey{fileName: "a.rio", rendition: rioThumb, version: "v1"}
+
+	var calls atomic.Int32
+	started, release := make(chan struct{}, 20), make(chan struct{})
+	gen := func() ([]byte, bool) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-release
+		return []byte("thumb"), true
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			if data, ok := s.generate(key, gen); !ok || string(data) != "thumb" {
+				t.Errorf("generate = %q, %v", data, ok)
+			}
+		})
+	}
+	<-started
+	time.Sleep(10 * time.Millisecond) // let the other requests queue up
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("thumbnail generated %d times, want 1", n)
+	}
+}
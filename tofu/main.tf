@@ -11,7 +11,8 @@ locals {
         "cloudbuild.googleapis.com",
         "artifactregistry.googleapis.com",
         "run.googleapis.com",
-        "compute.googleapis.com"
+        "compute.googleapis.com",
+        "secretmanager.googleapis.com"
     ]
     app_host = "0.0.0.0"
     app_port = 80
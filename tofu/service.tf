@@ -45,6 +45,37 @@ resource "google_cloud_run_v2_service" "app" {
         name = "REMOTE_PROFILE_URL"
         value = var.remote_profile_url
       }
+
+      dynamic "env" {
+        for_each = var.update_token_secret == "" ? [] : [var.update_token_secret]
+        content {
+          name = "UPDATE_TOKEN"
+          value_source {
+            secret_key_ref {
+              secret = env.value
+              version = "latest"
+            }
+          }
+        }
+      }
+
+      dynamic "env" {
+        for_each = var.scriptum_preview_token_secret == "" ? [] : [var.scriptum_preview_token_secret]
+        content {
+          name = "SCRIPTUM_PREVIEW_TOKEN"
+          value_source {
+            secret_key_ref {
+              secret = env.value
+              version = "latest"
+            }
+          }
+        }
+      }
+
+      env {
+        name = "TRUST_PROXY"
+        value = "true"
+      }
     }
   }
 
@@ -52,6 +83,22 @@ resource "google_cloud_run_v2_service" "app" {
     type = "TRAFFIC_TARGET_ALLOCATION_TYPE_LATEST"
     percent = 100
   }
+
+  depends_on = [ google_secret_manager_secret_iam_member.app_secrets ]
+}
+
+data "google_project" "project" {
+  project_id = var.project_id
+}
+
+# The secrets are created and given a value outside of tofu, so they never end
+# up in the state. The service runs as the default compute service account.
+resource "google_secret_manager_secret_iam_member" "app_secrets" {
+  for_each  = toset(compact([var.update_token_secret, var.scriptum_preview_token_secret]))
+  project   = var.project_id
+  secret_id = each.key
+  role      = "roles/secretmanager.secretAccessor"
+  member    = "serviceAccount:${data.google_project.project.number}-compute@developer.gserviceaccount.com"
 }
 
 data "google_iam_policy" "noauth" {
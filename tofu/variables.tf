@@ -26,4 +26,14 @@ variable repository {
 variable remote_profile_url {
     description = "The URL to the remote profile JSON file"
     type = string
+}
+variable update_token_secret {
+    description = "The Secret Manager secret holding the bearer token for /update/, empty disables the endpoint"
+    type = string
+    default = ""
+}
+variable scriptum_preview_token_secret {
+    description = "The Secret Manager secret holding the token that shows draft and scheduled posts via ?preview=, empty disables previews"
+    type = string
+    default = ""
 }
\ No newline at end of file
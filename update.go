@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	updateMaxBody     = 1 << 20
+	updateNonceTTL    = 24 * time.Hour
+	updateMaxSkew     = 5 * time.Minute
+	updateRateLimit   = 5 // requests per IP per window
+	updateRateWindow  = time.Minute
+	updateMaxProfile  = 1 << 20
+	updateProfilePath = "./profile.json"
+)
+
+// updater serves the /update/ webhook. Every request carries an
+// X-Update-Nonce that hasn't been seen in the last 24 hours, and
+// authenticates in one of two ways:
+//
+//   - A GitHub-style X-Hub-Signature-256 header, keyed by UPDATE_SECRET, over
+//     "timestamp.nonce.body", where timestamp is the X-Update-Timestamp header
+//     in Unix seconds. Requests older than updateMaxSkew are rejected, so a
+//     captured request can't be replayed, not even with a fresh nonce.
+//   - An Authorization: Bearer UPDATE_TOKEN header. The token doesn't cover
+//     the nonce, so for these the nonce only deduplicates repeated deliveries;
+//     anyone holding the token can send requests anyway.
+type updater struct {
+	secret      []byte
+	token       string
+	trustProxy  bool
+	gallery     *Gallery
+	profileTmpl *atomic.Pointer[template.Template]
+	scheduler   *scheduler
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	hits   map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+type updateSummary struct {
+	Profile    profileSync      `json:"profile"`
+	Gallery    *gallerySync     `json:"gallery,omitempty"`
+	ImageCache *imageCacheStats `json:"image_cache,omitempty"`
+	Scheduler  *syncStatus      `json:"scheduler,omitempty"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+type profileSync struct {
+	Changed bool `json:"changed"`
+}
+
+func newUpdater(g *Gallery, profileTmpl *atomic.Pointer[template.Template], secret, token string, trustProxy bool) *updater {
+	if secret == "" && token == "" {
+		slog.Warn("UPDATE_SECRET and UPDATE_TOKEN not set, /update/ is disabled")
+	}
+
+	return &updater{
+		secret:      []byte(secret),
+		token:       token,
+		trustProxy:  trustProxy,
+		gallery:     g,
+		profileTmpl: profileTmpl,
+		nonces:      map[string]time.Time{},
+		hits:        map[string]rateWindow{},
+	}
+}
+
+func (u *updater) updateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ip := clientIP(r, u.trustProxy)
+	if !u.allow(ip, time.Now()) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(updateRateWindow.Seconds())))
+		writeJSONError(w, http.StatusTooManyRequests, "too many requests")
+		slog.Warn("Rate limited update request", "ip", ip)
+		return
+	}
+
+	if len(u.secret) == 0 && u.token == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, "updates are disabled")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, updateMaxBody))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
+	nonce := r.Header.Get("X-Update-Nonce")
+	if nonce == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing X-Update-Nonce header")
+		return
+	}
+
+	if !u.authenticate(r, body, nonce, time.Now()) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid signature, timestamp or token")
+		slog.Warn("Unauthenticated update request", "ip", ip)
+		return
+	}
+
+	if !u.claimNonce(nonce, time.Now()) {
+		writeJSONError(w, http.StatusConflict, "delivery already processed")
+		slog.Warn("Replayed update request", "ip", ip, "nonce", nonce)
+		return
+	}
+
+	slog.Info("Update requested", "ip", ip, "nonce", nonce)
+
+	summary := runUpdate(u.gallery, u.profileTmpl.Load())
+	if u.scheduler != nil {
+		status := u.scheduler.Status()
+		summary.Scheduler = &status
+	}
+
+	status := http.StatusOK
+	if len(summary.Errors) > 0 {
+		status = http.StatusBadGateway
+	}
+
+	writeJSON(w, status, summary)
+}
+
+// runUpdate refreshes the profile and the gallery, collecting what changed.
+func runUpdate(g *Gallery, profileTmpl *template.Template) updateSummary {
+	var summary updateSummary
+
+	changed, err := updateProfile(profileTmpl)
+	if err != nil {
+		summary.Errors = append(summary.Errors, "profile: "+err.Error())
+		slog.Error("Failed to update profile", "err", err)
+	}
+	summary.Profile.Changed = changed
+
+	gs, err := updateGallery(g)
+	if err != nil {
+		summary.Errors = append(summary.Errors, "gallery: "+err.Error())
+		slog.Error("Failed to update gallery:", "err", err)
+	}
+	summary.Gallery = gs
+
+	stats := g.CacheStats()
+	summary.ImageCache = &stats
+
+	return summary
+}
+
+// updateProfile fetches the remote profile, validates it and swaps it in,
+// then writes it to disk. It reports whether it differs from the current one.
+func updateProfile(tmpl *template.Template) (bool, error) {
+	remoteProfile := os.Getenv("REMOTE_PROFILE_URL")
+	if remoteProfile == "" {
+		remoteProfile = profileFallback
+	}
+
+	req, err := http.NewRequest(http.MethodGet, remoteProfile, nil)
+	if err != nil {
+		return false, err
+	}
+
+	remoteProfileValidators.Lock()
+	if remoteProfileValidators.url == remoteProfile {
+		if remoteProfileValidators.etag != "" {
+			req.Header.Set("If-None-Match", remoteProfileValidators.etag)
+		}
+		if remoteProfileValidators.lastModified != "" {
+			req.Header.Set("If-Modified-Since", remoteProfileValidators.lastModified)
+		}
+	}
+	remoteProfileValidators.Unlock()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error getting remote profile object: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("remote profile returned status %d", res.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, updateMaxProfile))
+	if err != nil {
+		return false, fmt.Errorf("error reading profile object: %w", err)
+	}
+
+	current, _ := os.ReadFile(updateProfilePath)
+	if bytes.Equal(current, b) {
+		rememberProfileValidators(remoteProfile, res.Header)
+		return false, nil
+	}
+
+	if err := reloadProfile(b, tmpl); err != nil {
+		return false, fmt.Errorf("rejected new profile, keeping the current one: %w", err)
+	}
+
+	if err := writeFileAtomic(updateProfilePath, b); err != nil {
+		return false, fmt.Errorf("error writing profile object to disk: %w", err)
+	}
+	rememberProfileValidators(remoteProfile, res.Header)
+
+	slog.Info("Updated profile")
+	return true, nil
+}
+
+// remoteProfileValidators remembers the validators of the last profile
+// written to disk, so unchanged profiles are answered with 304.
+var remoteProfileValidators struct {
+	sync.Mutex
+	url          string
+	etag         string
+	lastModified string
+}
+
+func rememberProfileValidators(url string, h http.Header) {
+	remoteProfileValidators.Lock()
+	defer remoteProfileValidators.Unlock()
+
+	remoteProfileValidators.url = url
+	remoteProfileValidators.etag = h.Get("ETag")
+	remoteProfileValidators.lastModified = h.Get("Last-Modified")
+}
+
+func (u *updater) authenticate(r *http.Request, body []byte, nonce string, now time.Time) bool {
+	if sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok && len(u.secret) > 0 {
+		got, err := hex.DecodeString(sig)
+		if err != nil {
+			return false
+		}
+
+		ts := r.Header.Get("X-Update-Timestamp")
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil || now.Sub(time.Unix(sec, 0)).Abs() > updateMaxSkew {
+			return false
+		}
+
+		return hmac.Equal(got, signUpdate(u.secret, ts, nonce, body))
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && u.token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(u.token)) == 1
+	}
+
+	return false
+}
+
+// signUpdate returns the HMAC-SHA256 of "timestamp.nonce.body".
+func signUpdate(secret []byte, timestamp, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// claimNonce records a delivery ID, reporting false if it was already used.
+func (u *updater) claimNonce(nonce string, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for n, seen := range u.nonces {
+		if now.Sub(seen) > updateNonceTTL {
+			delete(u.nonces, n)
+		}
+	}
+
+	if _, ok := u.nonces[nonce]; ok {
+		return false
+	}
+
+	u.nonces[nonce] = now
+	return true
+}
+
+// allow counts a request from ip in a fixed window rate limiter.
+func (u *updater) allow(ip string, now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for addr, win := range u.hits {
+		if now.Sub(win.start) > updateRateWindow {
+			delete(u.hits, addr)
+		}
+	}
+
+	win, ok := u.hits[ip]
+	if !ok {
+		win = rateWindow{start: now}
+	}
+	win.count++
+	u.hits[ip] = win
+
+	return win.count <= updateRateLimit
+}
+
+// clientIP returns the address of the client. Behind a proxy such as Cloud
+// Run's front end, the last X-Forwarded-For hop is the one the proxy added.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateAuthenticate(t *testing.T) {
+	u := newUpdater(nil, nil, "secret", "token", false)
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"ref":"main"}`)
+
+	signed := func(secret string, ts time.Time, nonce string, body []byte) http.Header {
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		return http.Header{
+			"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(signUpdate([]byte(secret), stamp, nonce, body))},
+			"X-Update-Timestamp":  {stamp},
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		nonce  string
+		want   bool
+	}{
+		{"signed", signed("secret", now, "n1", body), "n1", true},
+		{"signed a minute ago", signed("secret", now.Add(-time.Minute), "n1", body), "n1", true},
+		{"stale timestamp", signed("secret", now.Add(-updateMaxSkew-time.Second), "n1", body), "n1", false},
+		{"future timestamp", signed("secret", now.Add(updateMaxSkew+time.Second), "n1", body), "n1", false},
+		{"replayed with a new nonce", signed("secret", now, "n1", body), "n2", false},
+		{"other body", signed("secret", now, "n1", []byte("{}")), "n1", false},
+		{"wrong secret", signed("other", now, "n1", body), "n1", false},
+		{"missing timestamp", http.Header{"X-Hub-Signature-256": signed("secret", now, "n1", body)["X-Hub-Signature-256"]}, "n1", false},
+		{"bad hex", http.Header{"X-Hub-Signature-256": {"sha256=zz"}, "X-Update-Timestamp": {"1700000000"}}, "n1", false},
+		{"bearer", http.Header{"Authorization": {"Bearer token"}}, "n1", true},
+		{"wrong bearer", http.Header{"Authorization": {"Bearer nope"}}, "n1", false},
+		{"nothing", http.Header{}, "n1", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			r.Header = tt.header
+			if got := u.authenticate(r, body, tt.nonce, now); got != tt.want {
+				t.Errorf("authenticate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	disabled := newUpdater(nil, nil, "", "", false)
+	r := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	if disabled.authenticate(r, nil, "n1", now) {
+		t.Error("empty token authenticated with no UPDATE_TOKEN set")
+	}
+}
+
+func TestUpdateClaimNonce(t *testing.T) {
+	u := newUpdater(nil, nil, "secret", "", false)
+	now := time.Now()
+
+	if !u.claimNonce("a", now) {
+		t.Fatal("first use of a nonce was rejected")
+	}
+	if u.claimNonce("a", now.Add(time.Hour)) {
+		t.Error("nonce reused within its TTL was accepted")
+	}
+	if !u.claimNonce("b", now.Add(time.Hour)) {
+		t.Error("a different nonce was rejected")
+	}
+	if !u.claimNonce("a", now.Add(updateNonceTTL+time.Second)) {
+		t.Error("nonce was still remembered after its TTL")
+	}
+}
+
+func TestUpdateAllow(t *testing.T) {
+	u := newUpdater(nil, nil, "secret", "", false)
+	now := time.Now()
+
+	for i := range updateRateLimit {
+		if !u.allow("1.2.3.4", now) {
+			t.Fatalf("request %d was rate limited", i+1)
+		}
+	}
+	if u.allow("1.2.3.4", now) {
+		t.Error("request over the limit was allowed")
+	}
+	if !u.allow("5.6.7.8", now) {
+		t.Error("another address was rate limited")
+	}
+	if !u.allow("1.2.3.4", now.Add(updateRateWindow+time.Second)) {
+		t.Error("limit wasn't reset after the window")
+	}
+}
+
+func TestUpdateHandlerRejects(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		method string
+		header http.Header
+		want   int
+	}{
+		{"GET", http.MethodGet, nil, http.StatusMethodNotAllowed},
+		{"missing nonce", http.MethodPost, http.Header{"Authorization": {"Bearer token"}}, http.StatusBadRequest},
+		{"unauthenticated", http.MethodPost, http.Header{"X-Update-Nonce": {"n"}}, http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUpdater(nil, nil, "secret", "token", false)
+			r := httptest.NewRequest(tt.method, "/update/", strings.NewReader("{}"))
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			u.updateHandler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
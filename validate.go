@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a validation problem at a path such as
+// sections[2].entries[0].url.
+type FieldError struct {
+	Path string
+	Msg  string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// ValidationError holds every problem found in a document.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validateProfileJSON decodes a profile, rejecting unknown fields, kinds out
+// of range, malformed URLs and local asset paths missing from staticFiles.
+// Problems are returned together as a ValidationError.
+func validateProfileJSON(b []byte) (*Profile, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, ValidationError{{Msg: "invalid JSON: " + err.Error()}}
+	}
+
+	var errs ValidationError
+	checkFields(&errs, "", raw, reflect.TypeFor[Profile]())
+
+	p := new(Profile)
+	if err := json.Unmarshal(b, p); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			errs = append(errs, FieldError{Path: bracketPath(typeErr.Field), Msg: "expected " + typeErr.Type.Kind().String() + ", got " + typeErr.Value})
+		} else {
+			errs = append(errs, FieldError{Msg: err.Error()})
+		}
+		return nil, errs
+	}
+
+	errs = append(errs, p.validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return p, nil
+}
+
+func (p *Profile) validate() ValidationError {
+	var errs ValidationError
+
+	if strings.TrimSpace(p.Name) == "" {
+		errs = append(errs, FieldError{"name", "is required"})
+	}
+	checkAsset(&errs, "profilePicture", p.Pic)
+	checkAsset(&errs, "favicon", p.Favicon)
+
+	for i, s := range p.Sections {
+		path := fmt.Sprintf("sections[%d]", i)
+
+		if s.Kind < About || s.Kind > Links {
+			errs = append(errs, FieldError{path + ".kind", fmt.Sprintf("unknown kind %d", s.Kind)})
+		}
+		if strings.TrimSpace(s.Title) == "" {
+			errs = append(errs, FieldError{path + ".title", "is required"})
+		}
+
+		for j, e := range s.Entries {
+			path := fmt.Sprintf("%s.entries[%d]", path, j)
+
+			if strings.TrimSpace(e.Name) == "" && strings.TrimSpace(e.Description) == "" {
+				errs = append(errs, FieldError{path, "needs an entryName or an entryDescription"})
+			}
+			checkURL(&errs, path+".url", e.Url)
+			checkAsset(&errs, path+".projectIcon", e.Icon)
+		}
+	}
+
+	return errs
+}
+
+// checkFields reports keys of obj that don't match a JSON tag of t, recursing
+// into nested structs and slices of structs.
+func checkFields(errs *ValidationError, path string, obj map[string]any, t reflect.Type) {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		fields[name] = f.Type
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(obj)) {
+		val := obj[key]
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		ft, ok := fields[key]
+		if !ok {
+			*errs = append(*errs, FieldError{keyPath, "unknown field"})
+			continue
+		}
+
+		switch {
+		case ft.Kind() == reflect.Struct:
+			if m, ok := val.(map[string]any); ok {
+				checkFields(errs, keyPath, m, ft)
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			items, _ := val.([]any)
+			for i, item := range items {
+				if m, ok := item.(map[string]any); ok {
+					checkFields(errs, fmt.Sprintf("%s[%d]", keyPath, i), m, ft.Elem())
+				}
+			}
+		}
+	}
+}
+
+// bracketPath turns encoding/json's sections.1.kind into sections[1].kind.
+func bracketPath(field string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func checkURL(errs *ValidationError, path, raw string) {
+	if raw == "" {
+		return
+	}
+
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		*errs = append(*errs, FieldError{path, "invalid URL: " + err.Error()})
+	case u.IsAbs() && u.Host == "" && u.Scheme != "mailto":
+		*errs = append(*errs, FieldError{path, "URL has no host"})
+	case !u.IsAbs() && !strings.HasPrefix(u.Path, "/"):
+		*errs = append(*errs, FieldError{path, "URL must be absolute or start with /"})
+	}
+}
+
+// checkAsset validates a URL and, for /static/ paths, that the file is
+// embedded in the binary.
+func checkAsset(errs *ValidationError, path, raw string) {
+	checkURL(errs, path, raw)
+
+	name, ok := strings.CutPrefix(raw, "/static/")
+	if !ok {
+		return
+	}
+
+	if _, err := fs.Stat(staticFiles, "static/"+name); err != nil {
+		*errs = append(*errs, FieldError{path, raw + " is not an embedded static file"})
+	}
+}
+
+// validateProfileCmd implements the validate-profile subcommand, printing
+// every problem in the given profile file (profile.json by default).
+func validateProfileCmd(args []string) int {
+	path := "./profile.json"
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if _, err := validateProfileJSON(b); err != nil {
+		var verr ValidationError
+		if errors.As(err, &verr) {
+			for _, e := range verr {
+				fmt.Fprintln(os.Stderr, path+": "+e.Error())
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, path+": "+err.Error())
+		}
+		return 1
+	}
+
+	fmt.Println(path + " is valid")
+	return 0
+}
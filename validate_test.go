@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestValidateProfileJSON(t *testing.T) {
+	b, err := os.ReadFile("profile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validateProfileJSON(b); err != nil {
+		t.Errorf("profile.json: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		json string
+		want []string
+	}{
+		{
+			"invalid JSON",
+			`{"name": `,
+			[]string{"invalid JSON: unexpected end of JSON input"},
+		},
+		{
+			"missing name",
+			`{"name": " "}`,
+			[]string{"name: is required"},
+		},
+		{
+			"unknown fields",
+			`{"name": "x", "nickname": "y", "sections": [{"title": "t", "kind": 0, "entries": [{"entryName": "e", "colour": "red"}]}]}`,
+			[]string{"nickname: unknown field", "sections[0].entries[0].colour: unknown field"},
+		},
+		{
+			"wrong type",
+			`{"name": "x", "sections": [{"title": "t", "kind": 0}, {"title": "u", "kind": "links"}]}`,
+			[]string{"sections[1].kind: expected int, got string"},
+		},
+		{
+			"section and entry problems",
+			`{"name": "x", "sections": [
+				{"title": "t", "kind": 0},
+				{"title": "", "kind": 9, "entries": [
+					{"entryName": "ok"},
+					{"url": "example.com"},
+					{"entryName": "e", "url": "https://", "projectIcon": "/static/assets/missing.png"}
+				]}
+			]}`,
+			[]string{
+				"sections[1].kind: unknown kind 9",
+				"sections[1].title: is required",
+				"sections[1].entries[1]: needs an entryName or an entryDescription",
+				"sections[1].entries[1].url: URL must be absolute or start with /",
+				"sections[1].entries[2].url: URL has no host",
+				"sections[1].entries[2].projectIcon: /static/assets/missing.png is not an embedded static file",
+			},
+		},
+		{
+			"assets",
+			`{"name": "x", "profilePicture": "/static/assets/profile.webp", "favicon": "/static/nope.ico"}`,
+			[]string{"favicon: /static/nope.ico is not an embedded static file"},
+		},
+		{
+			"mailto",
+			`{"name": "x", "sections": [{"title": "t", "kind": 2, "entries": [{"entryName": "mail", "url": "mailto:me@example.com"}]}]}`,
+			nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateProfileJSON([]byte(tt.json))
+
+			var got []string
+			var verr ValidationError
+			if errors.As(err, &verr) {
+				for _, e := range verr {
+					got = append(got, e.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("got %T %v, want a ValidationError", err, err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got errors\n\t%q\nwant\n\t%q", got, tt.want)
+			}
+		})
+	}
+}